@@ -0,0 +1,71 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// cancelSubjectPrefix is the prefix of the subjects clients publish to when they cancel a request.
+// The rest of the subject is the request's cancel id, see cancelIDHeader.
+const cancelSubjectPrefix = "_STORMRPC.CANCEL."
+
+// cancelTombstoneTTL is how long a cancel notice is kept for a request that hasn't arrived yet.
+var cancelTombstoneTTL = time.Minute
+
+// cancelTombstone marks a cancel id whose notice arrived before the request.
+type cancelTombstone struct{}
+
+// subscribeCancels cancels the context of requests whose client published a cancel notice.
+func (s *Server) subscribeCancels() error {
+	_, err := s.nc.Subscribe(cancelSubjectPrefix+"*", func(msg *nats.Msg) {
+		id := strings.TrimPrefix(msg.Subject, cancelSubjectPrefix)
+
+		// The notice can overtake its request, since they're sent on different subjects.
+		v, loaded := s.cancels.LoadOrStore(id, cancelTombstone{})
+		if !loaded {
+			time.AfterFunc(cancelTombstoneTTL, func() {
+				s.cancels.CompareAndDelete(id, cancelTombstone{})
+			})
+			return
+		}
+		if cancel, ok := v.(context.CancelFunc); ok {
+			cancel()
+		}
+	})
+
+	return err
+}
+
+// cancelable returns a copy of ctx that is canceled when the client that sent the request cancels it.
+// The returned function must be called once the request has been handled.
+func (s *Server) cancelable(ctx context.Context, header nats.Header) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	id := header.Get(cancelIDHeader)
+	if id == "" {
+		return ctx, cancel
+	}
+
+	if v, loaded := s.cancels.LoadOrStore(id, cancel); loaded {
+		if _, ok := v.(cancelTombstone); ok {
+			// The request was canceled before it arrived.
+			cancel()
+			s.cancels.CompareAndDelete(id, cancelTombstone{})
+		}
+		return ctx, cancel
+	}
+
+	return ctx, func() {
+		s.cancels.Delete(id)
+		cancel()
+	}
+}
+
+// cancel notifies the server handling the request with the given cancel id that the caller gave up on it.
+func (c *Client) cancel(id string) {
+	_ = c.nc.Publish(cancelSubjectPrefix+id, nil)
+}
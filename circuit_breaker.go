@@ -0,0 +1,224 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	defaultConsecutiveFailures = 5
+	defaultFailureRateWindow   = 10 * time.Second
+	defaultOpenTimeout         = 5 * time.Second
+	defaultMinRequests         = 10
+)
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+// Circuit breaker states.
+const (
+	// CircuitClosed lets all requests through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails all requests with ErrorCodeUnavailable without sending them.
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of trial requests through to decide whether to close the circuit again.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "Closed"
+	case CircuitOpen:
+		return "Open"
+	case CircuitHalfOpen:
+		return "HalfOpen"
+	default:
+		return "Unknown"
+	}
+}
+
+// CircuitBreaker configures the circuit breakers of a Client. Every subject gets its own circuit breaker.
+//
+// A closed circuit opens when either ConsecutiveFailures or the FailureRate is reached. While open, requests
+// fail immediately with ErrorCodeUnavailable. After OpenTimeout the next requests are let through as trial
+// requests, closing the circuit again when they all succeed or reopening it on the first failure.
+type CircuitBreaker struct {
+	// ConsecutiveFailures opens the circuit after the given number of consecutive failures.
+	// It defaults to 5 when FailureRate isn't set either.
+	ConsecutiveFailures int
+	// FailureRate opens the circuit when the fraction of failed requests within Window reaches it.
+	FailureRate float64
+	// MinRequests is the number of requests needed within Window before the FailureRate is considered.
+	// It defaults to 10.
+	MinRequests int
+	// Window is the period the FailureRate is computed over. It defaults to 10s.
+	Window time.Duration
+	// OpenTimeout is the time the circuit stays open before letting trial requests through. It defaults to 5s.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of trial requests let through while half-open. It defaults to 1.
+	HalfOpenRequests int
+	// FailureCodes are the error codes counted as failures. It defaults to ErrorCodeUnavailable,
+	// ErrorCodeDeadlineExceeded, and ErrorCodeInternal.
+	FailureCodes []ErrorCode
+	// OnStateChange is called whenever the circuit of a subject changes state, e.g. to record metrics.
+	// It is called while the circuit breaker is locked, so it must not block or call Client.CircuitState.
+	OnStateChange func(subject string, from, to CircuitState)
+}
+
+type circuitBreakers struct {
+	cfg CircuitBreaker
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	state       CircuitState
+	openedAt    time.Time
+	windowStart time.Time
+	requests    int
+	failures    int
+	consecutive int
+	trials      int
+	successes   int
+}
+
+func newCircuitBreakers(cfg CircuitBreaker) *circuitBreakers {
+	if cfg.ConsecutiveFailures <= 0 && cfg.FailureRate <= 0 {
+		cfg.ConsecutiveFailures = defaultConsecutiveFailures
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = defaultMinRequests
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = defaultFailureRateWindow
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = defaultOpenTimeout
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = 1
+	}
+	if len(cfg.FailureCodes) == 0 {
+		cfg.FailureCodes = []ErrorCode{ErrorCodeUnavailable, ErrorCodeDeadlineExceeded, ErrorCodeInternal}
+	}
+
+	return &circuitBreakers{
+		cfg:      cfg,
+		circuits: make(map[string]*circuit),
+	}
+}
+
+func (b *circuitBreakers) do(ctx context.Context, r Request, send func(context.Context, Request) Response) Response {
+	subject := r.Subject()
+	if err := b.allow(subject); err != nil {
+		return NewErrorResponse("", err)
+	}
+
+	resp := send(ctx, r)
+	b.record(subject, resp.Err)
+
+	return resp
+}
+
+func (b *circuitBreakers) state(subject string) CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[subject]
+	if !ok {
+		return CircuitClosed
+	}
+
+	return c.state
+}
+
+func (b *circuitBreakers) allow(subject string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[subject]
+	if !ok {
+		c = &circuit{windowStart: time.Now()}
+		b.circuits[subject] = c
+	}
+
+	switch c.state {
+	case CircuitOpen:
+		if time.Since(c.openedAt) < b.cfg.OpenTimeout {
+			return Errorf(ErrorCodeUnavailable, "circuit breaker is open for subject: %s", subject)
+		}
+		b.setState(subject, c, CircuitHalfOpen)
+		fallthrough
+	case CircuitHalfOpen:
+		if c.trials >= b.cfg.HalfOpenRequests {
+			return Errorf(ErrorCodeUnavailable, "circuit breaker is half open for subject: %s", subject)
+		}
+		c.trials++
+	default:
+		if time.Since(c.windowStart) >= b.cfg.Window {
+			c.windowStart = time.Now()
+			c.requests, c.failures = 0, 0
+		}
+	}
+
+	return nil
+}
+
+func (b *circuitBreakers) record(subject string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuits[subject]
+	failure := err != nil && slices.Contains(b.cfg.FailureCodes, CodeFromErr(err))
+
+	switch c.state {
+	case CircuitHalfOpen:
+		switch {
+		case failure:
+			b.setState(subject, c, CircuitOpen)
+		case CodeFromErr(err) == ErrorCodeCanceled:
+			// The caller gave up, which says nothing about the health of the server.
+			c.trials--
+		default:
+			c.successes++
+			if c.successes >= b.cfg.HalfOpenRequests {
+				b.setState(subject, c, CircuitClosed)
+			}
+		}
+	case CircuitClosed:
+		c.requests++
+		if !failure {
+			c.consecutive = 0
+			return
+		}
+		c.failures++
+		c.consecutive++
+
+		if b.cfg.ConsecutiveFailures > 0 && c.consecutive >= b.cfg.ConsecutiveFailures {
+			b.setState(subject, c, CircuitOpen)
+			return
+		}
+		if b.cfg.FailureRate > 0 && c.requests >= b.cfg.MinRequests &&
+			float64(c.failures)/float64(c.requests) >= b.cfg.FailureRate {
+			b.setState(subject, c, CircuitOpen)
+		}
+	}
+}
+
+// setState must be called with b.mu held.
+func (b *circuitBreakers) setState(subject string, c *circuit, state CircuitState) {
+	from := c.state
+	*c = circuit{state: state, windowStart: time.Now()}
+	if state == CircuitOpen {
+		c.openedAt = time.Now()
+	}
+
+	if b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(subject, from, state)
+	}
+}
@@ -0,0 +1,176 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_circuitBreakers(t *testing.T) {
+	unavailable := Errorf(ErrorCodeUnavailable, "unavailable")
+	notFound := Errorf(ErrorCodeNotFound, "not found")
+
+	t.Run("consecutive failures", func(t *testing.T) {
+		b := newCircuitBreakers(CircuitBreaker{ConsecutiveFailures: 2})
+		for _, err := range []error{unavailable, nil, unavailable, notFound, unavailable} {
+			if err := b.allow("test"); err != nil {
+				t.Fatal(err)
+			}
+			b.record("test", err)
+		}
+		if got := b.state("test"); got != CircuitClosed {
+			t.Fatalf("got = %v, want %v", got, CircuitClosed)
+		}
+
+		_ = b.allow("test")
+		b.record("test", unavailable)
+		if got := b.state("test"); got != CircuitOpen {
+			t.Fatalf("got = %v, want %v", got, CircuitOpen)
+		}
+
+		err := b.allow("test")
+		if CodeFromErr(err) != ErrorCodeUnavailable {
+			t.Fatalf("got = %v, want %v", CodeFromErr(err), ErrorCodeUnavailable)
+		}
+
+		if got := b.state("other"); got != CircuitClosed {
+			t.Fatalf("got = %v, want %v", got, CircuitClosed)
+		}
+	})
+
+	t.Run("failure rate", func(t *testing.T) {
+		b := newCircuitBreakers(CircuitBreaker{FailureRate: 0.5, MinRequests: 4})
+		for _, err := range []error{unavailable, nil, nil} {
+			_ = b.allow("test")
+			b.record("test", err)
+		}
+		if got := b.state("test"); got != CircuitClosed {
+			t.Fatalf("got = %v, want %v", got, CircuitClosed)
+		}
+
+		_ = b.allow("test")
+		b.record("test", unavailable)
+		if got := b.state("test"); got != CircuitOpen {
+			t.Fatalf("got = %v, want %v", got, CircuitOpen)
+		}
+	})
+
+	t.Run("failure rate window", func(t *testing.T) {
+		b := newCircuitBreakers(CircuitBreaker{FailureRate: 0.5, MinRequests: 2, Window: 20 * time.Millisecond})
+		_ = b.allow("test")
+		b.record("test", unavailable)
+
+		time.Sleep(30 * time.Millisecond)
+		_ = b.allow("test")
+		b.record("test", nil)
+		if got := b.state("test"); got != CircuitClosed {
+			t.Fatalf("got = %v, want %v", got, CircuitClosed)
+		}
+	})
+
+	t.Run("half open", func(t *testing.T) {
+		var changes []string
+		b := newCircuitBreakers(CircuitBreaker{
+			ConsecutiveFailures: 1,
+			OpenTimeout:         20 * time.Millisecond,
+			OnStateChange: func(subject string, from, to CircuitState) {
+				changes = append(changes, fmt.Sprintf("%s:%v->%v", subject, from, to))
+			},
+		})
+		_ = b.allow("test")
+		b.record("test", unavailable)
+
+		time.Sleep(30 * time.Millisecond)
+		if err := b.allow("test"); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.state("test"); got != CircuitHalfOpen {
+			t.Fatalf("got = %v, want %v", got, CircuitHalfOpen)
+		}
+		if err := b.allow("test"); CodeFromErr(err) != ErrorCodeUnavailable {
+			t.Fatalf("got = %v, want %v", CodeFromErr(err), ErrorCodeUnavailable)
+		}
+
+		b.record("test", unavailable)
+		if got := b.state("test"); got != CircuitOpen {
+			t.Fatalf("got = %v, want %v", got, CircuitOpen)
+		}
+
+		time.Sleep(30 * time.Millisecond)
+		_ = b.allow("test")
+		b.record("test", nil)
+		if got := b.state("test"); got != CircuitClosed {
+			t.Fatalf("got = %v, want %v", got, CircuitClosed)
+		}
+
+		want := []string{
+			"test:Closed->Open",
+			"test:Open->HalfOpen",
+			"test:HalfOpen->Open",
+			"test:Open->HalfOpen",
+			"test:HalfOpen->Closed",
+		}
+		if !slices.Equal(changes, want) {
+			t.Fatalf("got = %v, want %v", changes, want)
+		}
+	})
+}
+
+func TestClient_DoCircuitBreaker(t *testing.T) {
+	clientURL := startNatsServer(t)
+
+	srv, err := NewServer(&ServerConfig{
+		NatsURL: clientURL,
+		Name:    "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls atomic.Int64
+	srv.Handle("failing", func(ctx context.Context, r Request) Response {
+		calls.Add(1)
+		return NewErrorResponse(r.Reply, Errorf(ErrorCodeInternal, "broken"))
+	})
+
+	go func() {
+		_ = srv.Run()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown(context.Background())
+	})
+
+	if !srv.ready(250 * time.Millisecond) {
+		t.Fatal("server not ready after 250 milliseconds")
+	}
+
+	client, err := NewClient(clientURL, WithCircuitBreaker(CircuitBreaker{ConsecutiveFailures: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	for i := 0; i < 4; i++ {
+		resp := client.Do(ctxWithTimeout(t, time.Second), mustNewRequest(t, "failing", nil))
+		if resp.Err == nil {
+			t.Fatal("expected error got nil")
+		}
+	}
+
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("got = %v, want %v", got, 2)
+	}
+
+	if got := client.CircuitState("failing"); got != CircuitOpen {
+		t.Fatalf("got = %v, want %v", got, CircuitOpen)
+	}
+
+	resp := client.Do(ctxWithTimeout(t, time.Second), mustNewRequest(t, "failing", nil))
+	if CodeFromErr(resp.Err) != ErrorCodeUnavailable {
+		t.Fatalf("got = %v, want %v", CodeFromErr(resp.Err), ErrorCodeUnavailable)
+	}
+}
@@ -4,16 +4,31 @@ package stormrpc
 import (
 	"context"
 	"errors"
+	"fmt"
 
+	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
 // Client represents a stormRPC client. It contains all functionality for making RPC requests
 // to stormRPC servers.
 type Client struct {
-	nc *nats.Conn
+	nc            *nats.Conn
+	invoke        Invoker
+	publishInvoke Invoker
+	retry         *RetryPolicy
+	breakers      *circuitBreakers
+	payloads      *payloadStore
+	propagate     []string
 }
 
+// Invoker is the function signature for completing a single request with a Client.
+type Invoker func(ctx context.Context, r Request, opts ...CallOption) Response
+
+// ClientMiddleware is the function signature for wrapping a Client's requests to extend their functionality.
+type ClientMiddleware func(next Invoker) Invoker
+
 // NewClient returns a new instance of a Client.
 func NewClient(natsURL string, opts ...ClientOption) (*Client, error) {
 	options := clientOptions{}
@@ -30,9 +45,29 @@ func NewClient(natsURL string, opts ...ClientOption) (*Client, error) {
 		}
 	}
 
-	return &Client{
-		nc: options.nc,
-	}, nil
+	c := &Client{
+		nc:        options.nc,
+		retry:     options.retry,
+		payloads:  newPayloadStore(options.nc, options.largePayloads),
+		propagate: options.propagate,
+	}
+
+	if options.circuitBreaker != nil {
+		c.breakers = newCircuitBreakers(*options.circuitBreaker)
+	}
+
+	c.invoke = chainClientMiddleware(c.do, options.mw)
+	c.publishInvoke = chainClientMiddleware(c.publish, options.mw)
+
+	return c, nil
+}
+
+func chainClientMiddleware(invoke Invoker, mw []ClientMiddleware) Invoker {
+	for i := len(mw) - 1; i >= 0; i-- {
+		invoke = mw[i](invoke)
+	}
+
+	return invoke
 }
 
 // Close closes the underlying nats connection.
@@ -40,10 +75,16 @@ func (c *Client) Close() {
 	c.nc.Close()
 }
 
-// Do completes a request to a stormRPC Server.
+// Do completes a request to a stormRPC Server. The request passes through the
+// ClientMiddleware the Client was created with.
 func (c *Client) Do(ctx context.Context, r Request, opts ...CallOption) Response {
+	return c.invoke(ctx, r, opts...)
+}
+
+func (c *Client) do(ctx context.Context, r Request, opts ...CallOption) Response {
 	options := callOptions{
 		headers: make(map[string]string),
+		retry:   c.retry,
 	}
 	for _, o := range opts {
 		err := o.before(&options)
@@ -52,24 +93,92 @@ func (c *Client) Do(ctx context.Context, r Request, opts ...CallOption) Response
 		}
 	}
 
-	applyOptions(&r, &options)
+	c.applyOptions(ctx, &r, &options)
+
+	if options.compress != nil {
+		var err error
+		if r, err = options.compress.compressRequest(r); err != nil {
+			return NewErrorResponse("", err)
+		}
+	}
+
+	r, name, err := c.payloads.storeRequest(ctx, r)
+	if err != nil {
+		return NewErrorResponse("", err)
+	}
+	// Durable requests may be redelivered after the call returns, so their bodies are left to expire.
+	if name != "" && !options.durable {
+		defer c.payloads.delete(name)
+	}
+
+	send := c.send
+	if options.durable {
+		send = c.sendDurable
+	}
+
+	if options.hedging.enabled() {
+		if !options.idempotent {
+			return NewErrorResponse("", Errorf(ErrorCodeInvalidArgument, "hedging is only allowed for idempotent calls"))
+		}
+		attempt := send
+		send = func(ctx context.Context, r Request) Response {
+			return options.hedging.do(ctx, r, attempt)
+		}
+	}
+
+	if options.retry.enabled() {
+		attempt := send
+		send = func(ctx context.Context, r Request) Response {
+			return options.retry.do(ctx, r, attempt)
+		}
+	}
 
+	var resp Response
+	if c.breakers != nil {
+		resp = c.breakers.do(ctx, r, send)
+	} else {
+		resp = send(ctx, r)
+	}
+	resp.payloads = c.payloads
+
+	if resp.Msg != nil {
+		options.responseHeader = resp.Header
+	}
+	for _, o := range opts {
+		o.after(&options)
+	}
+
+	return resp
+}
+
+// CircuitState returns the state of the circuit breaker for the given subject.
+// Clients created without WithCircuitBreaker always report CircuitClosed.
+func (c *Client) CircuitState(subject string) CircuitState {
+	if c.breakers == nil {
+		return CircuitClosed
+	}
+
+	return c.breakers.state(subject)
+}
+
+// send completes a single attempt of a request.
+func (c *Client) send(ctx context.Context, r Request) Response {
 	dl, ok := ctx.Deadline()
 	if ok {
 		setDeadlineHeader(r.Header, dl)
 	}
 
+	id := uuid.NewString()
+	r.Header.Set(cancelIDHeader, id)
+
 	msg, err := c.nc.RequestMsgWithContext(ctx, r.Msg)
-	if errors.Is(err, nats.ErrNoResponders) {
-		return Response{
-			Msg: msg,
-			Err: Errorf(ErrorCodeInternal, "no servers available for subject: %s", r.Subject()),
-		}
-	}
 	if err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			c.cancel(id)
+		}
 		return Response{
 			Msg: msg,
-			Err: err, // TODO: probably use errorf and inspect different error types from nats.
+			Err: requestError(err, r.Subject()),
 		}
 	}
 
@@ -88,8 +197,55 @@ func (c *Client) Do(ctx context.Context, r Request, opts ...CallOption) Response
 	}
 }
 
-func applyOptions(r *Request, options *callOptions) {
+// applyOptions sets the headers of a request made with ctx, which are, in order of precedence,
+// the headers set by the CallOptions, the headers already set on the request, the outgoing headers
+// of ctx, and the headers of the request being handled the Client propagates.
+func (c *Client) applyOptions(ctx context.Context, r *Request, options *callOptions) {
+	if r.Header == nil {
+		r.Header = nats.Header{}
+	}
+	for k, v := range OutgoingHeadersFromContext(ctx) {
+		if _, ok := r.Header[k]; !ok {
+			r.Header[k] = v
+		}
+	}
+	if len(c.propagate) > 0 {
+		inbound := HeadersFromContext(ctx)
+		for _, k := range c.propagate {
+			if _, ok := r.Header[k]; !ok && len(inbound[k]) > 0 {
+				r.Header[k] = append([]string(nil), inbound[k]...)
+			}
+		}
+	}
+
 	for k, v := range options.headers {
 		r.Header.Set(k, v)
 	}
+	if options.accept != "" {
+		r.Header.Set(acceptHeader, options.accept)
+	}
+}
+
+// requestError converts an error returned by nats while making a request to an Error.
+func requestError(err error, subject string) error {
+	switch {
+	case errors.Is(err, nats.ErrNoResponders):
+		return &Error{
+			Code:    ErrorCodeUnavailable,
+			Message: fmt.Sprintf("no servers available for subject: %s", subject),
+			cause:   err,
+		}
+	case errors.Is(err, jetstream.ErrNoStreamResponse):
+		return &Error{
+			Code:    ErrorCodeUnavailable,
+			Message: fmt.Sprintf("no stream available for subject: %s", subject),
+			cause:   err,
+		}
+	case errors.Is(err, nats.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return wrapError(ErrorCodeDeadlineExceeded, err)
+	case errors.Is(err, context.Canceled):
+		return wrapError(ErrorCodeCanceled, err)
+	default:
+		return err
+	}
 }
@@ -6,13 +6,16 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"reflect"
 	"strconv"
 	"testing"
 	"time"
 
+	"github.com/actatum/stormrpc/prototest"
 	"github.com/google/uuid"
 	"github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
 )
 
 func TestNewClient(t *testing.T) {
@@ -143,6 +146,9 @@ func TestClient_Do(t *testing.T) {
 		if !errors.Is(resp.Err, context.DeadlineExceeded) {
 			t.Fatalf("got = %v, want %v", resp.Err, context.DeadlineExceeded)
 		}
+		if code := CodeFromErr(resp.Err); code != ErrorCodeDeadlineExceeded {
+			t.Fatalf("got = %v, want %v", code, ErrorCodeDeadlineExceeded)
+		}
 	})
 
 	t.Run("rpc error", func(t *testing.T) {
@@ -192,6 +198,97 @@ func TestClient_Do(t *testing.T) {
 		}
 	})
 
+	t.Run("rpc error with details", func(t *testing.T) {
+		subject := strconv.Itoa(rand.Int())
+		srv, err := NewServer(&ServerConfig{
+			NatsURL: clientURL,
+			Name:    "test",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv.Handle(subject, func(ctx context.Context, r Request) Response {
+			return NewErrorResponse(r.Reply, Errorf(ErrorCodeInvalidArgument, "invalid email: a@b:c").
+				WithDetails(FieldViolation{Field: "email", Description: "must not contain ':'"}).
+				WithMetadata("request_id", "1234"))
+		})
+		go func() {
+			_ = srv.Run()
+		}()
+		t.Cleanup(func() {
+			_ = srv.Shutdown(context.Background())
+		})
+		if !srv.ready(250 * time.Millisecond) {
+			t.Fatal("server not ready after 250 milliseconds")
+		}
+
+		client, err := NewClient(clientURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(client.Close)
+
+		resp := client.Do(ctxWithTimeout(t, 1*time.Second), mustNewRequest(t, subject, 0))
+		if code := CodeFromErr(resp.Err); code != ErrorCodeInvalidArgument {
+			t.Fatalf("got = %v, want %v", code, ErrorCodeInvalidArgument)
+		}
+		if msg := MessageFromErr(resp.Err); msg != "invalid email: a@b:c" {
+			t.Fatalf("got = %v, want %v", msg, "invalid email: a@b:c")
+		}
+
+		details := ErrorDetails(resp.Err)
+		if len(details) != 1 {
+			t.Fatalf("got = %v details, want %v", len(details), 1)
+		}
+		fv, ok := details[0].(FieldViolation)
+		if !ok || fv.Field != "email" {
+			t.Fatalf("got = %v, want %v", details[0], FieldViolation{Field: "email", Description: "must not contain ':'"})
+		}
+		if got := ErrorMetadata(resp.Err)["request_id"]; got != "1234" {
+			t.Fatalf("got = %v, want %v", got, "1234")
+		}
+	})
+
+	t.Run("micro service error", func(t *testing.T) {
+		nc, err := nats.Connect(clientURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(nc.Close)
+
+		subject := strconv.Itoa(rand.Int())
+		svc, err := micro.AddService(nc, micro.Config{
+			Name:    "plain",
+			Version: "0.1.0",
+			Endpoint: &micro.EndpointConfig{
+				Subject: subject,
+				Handler: micro.HandlerFunc(func(r micro.Request) {
+					_ = r.Error("404", "thingy: not found", nil)
+				}),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = svc.Stop()
+		})
+
+		client, err := NewClient(clientURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(client.Close)
+
+		resp := client.Do(ctxWithTimeout(t, 1*time.Second), mustNewRequest(t, subject, 0))
+		if code := CodeFromErr(resp.Err); code != ErrorCodeNotFound {
+			t.Fatalf("got = %v, want %v", code, ErrorCodeNotFound)
+		}
+		if msg := MessageFromErr(resp.Err); msg != "thingy: not found" {
+			t.Fatalf("got = %v, want %v", msg, "thingy: not found")
+		}
+	})
+
 	t.Run("no servers", func(t *testing.T) {
 		subject := strconv.Itoa(rand.Int())
 
@@ -213,8 +310,11 @@ func TestClient_Do(t *testing.T) {
 		}
 
 		code := CodeFromErr(resp.Err)
-		if code != ErrorCodeInternal {
-			t.Fatalf("got = %v, want %v", code, ErrorCodeInternal)
+		if code != ErrorCodeUnavailable {
+			t.Fatalf("got = %v, want %v", code, ErrorCodeUnavailable)
+		}
+		if !errors.Is(resp.Err, nats.ErrNoResponders) {
+			t.Fatalf("got = %v, want %v", resp.Err, nats.ErrNoResponders)
 		}
 		msg := MessageFromErr(resp.Err)
 		if msg != fmt.Sprintf("no servers available for subject: %s", subject) {
@@ -364,6 +464,234 @@ func TestClient_Do(t *testing.T) {
 			t.Fatalf("got = %v, want %v", result["hello"], "world")
 		}
 	})
+
+	t.Run("client middleware", func(t *testing.T) {
+		timeout := 50 * time.Millisecond
+		subject := strconv.Itoa(rand.Int())
+		srv, err := NewServer(&ServerConfig{
+			NatsURL: clientURL,
+			Name:    "test",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv.Handle(subject, func(ctx context.Context, r Request) Response {
+			return NewErrorResponse(r.Reply, Errorf(ErrorCodeNotFound, "order %s", r.Header.Get("X-Order")))
+		})
+		go func() {
+			_ = srv.Run()
+		}()
+		t.Cleanup(func() {
+			_ = srv.Shutdown(context.Background())
+		})
+
+		var calls []string
+		mw := func(name string) ClientMiddleware {
+			return func(next Invoker) Invoker {
+				return func(ctx context.Context, r Request, opts ...CallOption) Response {
+					calls = append(calls, name)
+					r.Header.Set("X-Order", r.Header.Get("X-Order")+name)
+					resp := next(ctx, r, opts...)
+					if CodeFromErr(resp.Err) != ErrorCodeNotFound {
+						t.Errorf("got = %v, want %v", CodeFromErr(resp.Err), ErrorCodeNotFound)
+					}
+					return resp
+				}
+			}
+		}
+
+		client, err := NewClient(clientURL, WithClientMiddleware(mw("a"), mw("b")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(client.Close)
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		r, err := NewRequest(subject, map[string]string{"howdy": "partner"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := client.Do(ctx, r)
+		if MessageFromErr(resp.Err) != "order ab" {
+			t.Fatalf("got = %v, want %v", MessageFromErr(resp.Err), "order ab")
+		}
+
+		if len(calls) != 2 || calls[0] != "a" || calls[1] != "b" {
+			t.Fatalf("got = %v, want %v", calls, []string{"a", "b"})
+		}
+	})
+
+	t.Run("content negotiation", func(t *testing.T) {
+		subject := strconv.Itoa(rand.Int())
+		srv, err := NewServer(&ServerConfig{
+			NatsURL: clientURL,
+			Name:    "test",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv.Handle(subject, func(ctx context.Context, r Request) Response {
+			var in prototest.HelloRequest
+			if err := r.Decode(&in); err != nil {
+				return NewErrorResponse(r.Reply, err)
+			}
+			resp, err := r.NewResponse(&prototest.HelloReply{Message: "hello " + in.GetName()}, WithEncodeProto())
+			if err != nil {
+				return NewErrorResponse(r.Reply, err)
+			}
+			return resp
+		})
+		go func() {
+			_ = srv.Run()
+		}()
+		t.Cleanup(func() {
+			_ = srv.Shutdown(context.Background())
+		})
+
+		client, err := NewClient(clientURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(client.Close)
+
+		tests := []struct {
+			name   string
+			accept []string
+			want   string
+		}{
+			{
+				name: "no accept header",
+				want: "application/protobuf",
+			},
+			{
+				name:   "protojson",
+				accept: []string{"application/protobuf+json"},
+				want:   "application/protobuf+json",
+			},
+			{
+				name:   "first supported",
+				accept: []string{"application/xml", "application/protobuf"},
+				want:   "application/protobuf",
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				r, err := NewRequest(subject, &prototest.HelloRequest{Name: "aaron"}, WithEncodeProto())
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				opts := []CallOption{WithHeaders(map[string]string{"X-Test": "true"})}
+				if tt.accept != nil {
+					opts = append(opts, WithAccept(tt.accept...))
+				}
+
+				resp := client.Do(ctxWithTimeout(t, time.Second), r, opts...)
+				if resp.Err != nil {
+					t.Fatal(resp.Err)
+				}
+				if resp.Header.Get("Content-Type") != tt.want {
+					t.Fatalf("got = %v, want %v", resp.Header.Get("Content-Type"), tt.want)
+				}
+
+				var out prototest.HelloReply
+				if err = resp.Decode(&out); err != nil {
+					t.Fatal(err)
+				}
+				if out.GetMessage() != "hello aaron" {
+					t.Fatalf("got = %v, want %v", out.GetMessage(), "hello aaron")
+				}
+			})
+		}
+	})
+
+	t.Run("header propagation", func(t *testing.T) {
+		front := strconv.Itoa(rand.Int())
+		back := strconv.Itoa(rand.Int())
+		srv, err := NewServer(&ServerConfig{
+			NatsURL: clientURL,
+			Name:    "test",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		downstream, err := NewClient(clientURL, WithPropagatedHeaders("X-Request-Id", "X-Tenant"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(downstream.Close)
+
+		srv.Handle(front, func(ctx context.Context, r Request) Response {
+			ctx = AppendOutgoingHeader(ctx, "X-Caller", "front")
+			req, err := NewRequest(back, nil)
+			if err != nil {
+				return NewErrorResponse(r.Reply, err)
+			}
+			req.Header.Set("X-Tenant", "override")
+
+			resp := downstream.Do(ctx, req)
+			if resp.Err != nil {
+				return NewErrorResponse(r.Reply, resp.Err)
+			}
+			resp.Subject = r.Reply
+			return resp
+		})
+		srv.Handle(back, func(ctx context.Context, r Request) Response {
+			h := HeadersFromContext(ctx)
+			resp, err := NewResponse(r.Reply, map[string]string{
+				"X-Request-Id": h.Get("X-Request-Id"),
+				"X-Tenant":     h.Get("X-Tenant"),
+				"X-Caller":     h.Get("X-Caller"),
+				"X-Secret":     h.Get("X-Secret"),
+			})
+			if err != nil {
+				return NewErrorResponse(r.Reply, err)
+			}
+			return resp
+		})
+		go func() {
+			_ = srv.Run()
+		}()
+		t.Cleanup(func() {
+			_ = srv.Shutdown(context.Background())
+		})
+
+		client, err := NewClient(clientURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(client.Close)
+
+		r, err := NewRequest(front, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := client.Do(ctxWithTimeout(t, time.Second), r, WithHeaders(map[string]string{
+			"X-Request-Id": "abc",
+			"X-Tenant":     "acme",
+			"X-Secret":     "hunter2",
+		}))
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+
+		var got map[string]string
+		if err = resp.Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{
+			"X-Request-Id": "abc",
+			"X-Tenant":     "override",
+			"X-Caller":     "front",
+			"X-Secret":     "",
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got = %v, want %v", got, want)
+		}
+	})
 }
 
 type optWithError struct{}
@@ -373,3 +701,48 @@ func (o *optWithError) before(*callOptions) error {
 }
 
 func (o *optWithError) after(*callOptions) {}
+
+func Test_requestError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorCode
+	}{
+		{
+			name: "no responders",
+			err:  nats.ErrNoResponders,
+			want: ErrorCodeUnavailable,
+		},
+		{
+			name: "nats timeout",
+			err:  nats.ErrTimeout,
+			want: ErrorCodeDeadlineExceeded,
+		},
+		{
+			name: "deadline exceeded",
+			err:  context.DeadlineExceeded,
+			want: ErrorCodeDeadlineExceeded,
+		},
+		{
+			name: "canceled",
+			err:  fmt.Errorf("request: %w", context.Canceled),
+			want: ErrorCodeCanceled,
+		},
+		{
+			name: "other error",
+			err:  nats.ErrConnectionClosed,
+			want: ErrorCodeUnknown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := requestError(tt.err, "test")
+			if code := CodeFromErr(got); code != tt.want {
+				t.Errorf("CodeFromErr() = %v, want %v", code, tt.want)
+			}
+			if !errors.Is(got, tt.err) {
+				t.Errorf("expected %v to wrap %v", got, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	contentTypeHeader = "Content-Type"
+	acceptHeader      = "Accept"
+)
+
+// Codec encodes and decodes request and response bodies of a single content type.
+type Codec interface {
+	// ContentType is the value of the Content-Type header of bodies encoded by the Codec.
+	ContentType() string
+	// Marshal encodes v.
+	Marshal(v any) ([]byte, error)
+	// Unmarshal decodes data into v.
+	Unmarshal(data []byte, v any) error
+}
+
+var codecs = struct {
+	sync.RWMutex
+	m map[string]Codec
+}{
+	m: make(map[string]Codec),
+}
+
+func init() {
+	for _, c := range []Codec{
+		JSONCodec{},
+		ProtoCodec{},
+		ProtoJSONCodec{},
+		MsgpackCodec{},
+		RawCodec{},
+		GobCodec{},
+	} {
+		RegisterCodec(c)
+	}
+}
+
+// RegisterCodec registers a Codec for its content type, replacing any Codec previously registered
+// for the same content type. Bodies are decoded with the Codec registered for their Content-Type header.
+func RegisterCodec(c Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+
+	codecs.m[mediaType(c.ContentType())] = c
+}
+
+// CodecFor returns the Codec registered for the content type. Parameters of the content type,
+// e.g. charset, are ignored. Bodies without a Content-Type header are JSON encoded.
+func CodecFor(contentType string) (Codec, error) {
+	if contentType == "" {
+		return JSONCodec{}, nil
+	}
+
+	codecs.RLock()
+	defer codecs.RUnlock()
+
+	c, ok := codecs.m[mediaType(contentType)]
+	if !ok {
+		return nil, Errorf(ErrorCodeInvalidArgument, "unsupported content type: %s", contentType)
+	}
+
+	return c, nil
+}
+
+// negotiateCodec returns the registered Codec for the most preferred content type of an Accept header.
+// It reports false if the header allows any content type or none of them are registered.
+func negotiateCodec(accept string) (Codec, bool) {
+	type mediaRange struct {
+		contentType string
+		q           float64
+	}
+
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		contentType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		mr := mediaRange{contentType: contentType, q: 1}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			mr.q = q
+		}
+		if mr.q > 0 {
+			ranges = append(ranges, mr)
+		}
+	}
+	slices.SortStableFunc(ranges, func(a, b mediaRange) int {
+		return cmp.Compare(b.q, a.q)
+	})
+
+	codecs.RLock()
+	defer codecs.RUnlock()
+
+	for _, mr := range ranges {
+		if mr.contentType == "*/*" {
+			return nil, false
+		}
+		if c, ok := codecs.m[mr.contentType]; ok {
+			return c, true
+		}
+	}
+
+	return nil, false
+}
+
+// mediaType returns the lowercased media type of a content type without its parameters.
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+
+	return mt
+}
+
+// JSONCodec encodes bodies as JSON. It's the default Codec.
+type JSONCodec struct{}
+
+// ContentType returns application/json.
+func (JSONCodec) ContentType() string {
+	return "application/json"
+}
+
+// Marshal encodes v using json.Marshal.
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes data using json.Unmarshal.
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// ProtoCodec encodes proto messages using the protobuf wire format.
+type ProtoCodec struct{}
+
+// ContentType returns application/protobuf.
+func (ProtoCodec) ContentType() string {
+	return "application/protobuf"
+}
+
+// Marshal encodes v using proto.Marshal.
+func (ProtoCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("failed to encode proto message: invalid type: %T", v)
+	}
+
+	return proto.Marshal(m)
+}
+
+// Unmarshal decodes data using proto.Unmarshal.
+func (ProtoCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("failed to decode proto message: invalid type: %T", v)
+	}
+
+	return proto.Unmarshal(data, m)
+}
+
+// ProtoJSONCodec encodes proto messages using the canonical JSON mapping of protobuf.
+type ProtoJSONCodec struct{}
+
+// ContentType returns application/protobuf+json.
+func (ProtoJSONCodec) ContentType() string {
+	return "application/protobuf+json"
+}
+
+// Marshal encodes v using protojson.Marshal.
+func (ProtoJSONCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("failed to encode proto message: invalid type: %T", v)
+	}
+
+	return protojson.Marshal(m)
+}
+
+// Unmarshal decodes data using protojson.Unmarshal.
+func (ProtoJSONCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("failed to decode proto message: invalid type: %T", v)
+	}
+
+	return protojson.Unmarshal(data, m)
+}
+
+// MsgpackCodec encodes bodies as MessagePack.
+type MsgpackCodec struct{}
+
+// ContentType returns application/msgpack.
+func (MsgpackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+// Marshal encodes v using msgpack.Marshal.
+func (MsgpackCodec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+// Unmarshal decodes data using msgpack.Unmarshal.
+func (MsgpackCodec) Unmarshal(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// RawCodec sends bodies as is. It encodes a []byte or string, and decodes into a *[]byte or *string.
+type RawCodec struct{}
+
+// ContentType returns application/octet-stream.
+func (RawCodec) ContentType() string {
+	return "application/octet-stream"
+}
+
+// Marshal returns v as bytes.
+func (RawCodec) Marshal(v any) ([]byte, error) {
+	switch b := v.(type) {
+	case []byte:
+		return b, nil
+	case string:
+		return []byte(b), nil
+	default:
+		return nil, fmt.Errorf("failed to encode raw body: invalid type: %T", v)
+	}
+}
+
+// Unmarshal copies data into v.
+func (RawCodec) Unmarshal(data []byte, v any) error {
+	switch b := v.(type) {
+	case *[]byte:
+		*b = append((*b)[:0], data...)
+	case *string:
+		*b = string(data)
+	default:
+		return fmt.Errorf("failed to decode raw body: invalid type: %T", v)
+	}
+
+	return nil
+}
+
+// GobCodec encodes bodies using encoding/gob. Both ends must be written in Go.
+type GobCodec struct{}
+
+// ContentType returns application/x-gob.
+func (GobCodec) ContentType() string {
+	return "application/x-gob"
+}
+
+// Marshal encodes v using a gob.Encoder.
+func (GobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes data using a gob.Decoder.
+func (GobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
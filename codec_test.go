@@ -0,0 +1,269 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/actatum/stormrpc/prototest"
+	"github.com/nats-io/nats.go"
+	"google.golang.org/protobuf/proto"
+)
+
+type upperCodec struct {
+	RawCodec
+}
+
+func (upperCodec) ContentType() string {
+	return "text/x-upper"
+}
+
+func (c upperCodec) Marshal(v any) ([]byte, error) {
+	b, err := c.RawCodec.Marshal(v)
+	return []byte(strings.ToUpper(string(b))), err
+}
+
+func TestCodecs(t *testing.T) {
+	type person struct {
+		Name string
+		Age  int
+	}
+
+	tests := []struct {
+		name  string
+		codec Codec
+		body  any
+		got   func() any
+	}{
+		{
+			name:  "json",
+			codec: JSONCodec{},
+			body:  map[string]string{"hello": "world"},
+			got:   func() any { return &map[string]string{} },
+		},
+		{
+			name:  "proto",
+			codec: ProtoCodec{},
+			body:  &prototest.HelloRequest{Name: "aaron"},
+			got:   func() any { return &prototest.HelloRequest{} },
+		},
+		{
+			name:  "protojson",
+			codec: ProtoJSONCodec{},
+			body:  &prototest.HelloRequest{Name: "aaron"},
+			got:   func() any { return &prototest.HelloRequest{} },
+		},
+		{
+			name:  "msgpack",
+			codec: MsgpackCodec{},
+			body:  map[string]string{"hello": "world"},
+			got:   func() any { return &map[string]string{} },
+		},
+		{
+			name:  "raw",
+			codec: RawCodec{},
+			body:  []byte("hello world"),
+			got:   func() any { return &[]byte{} },
+		},
+		{
+			name:  "gob",
+			codec: GobCodec{},
+			body:  person{Name: "aaron", Age: 30},
+			got:   func() any { return &person{} },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := NewRequest("test", tt.body, WithCodec(tt.codec))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Header.Get("Content-Type") != tt.codec.ContentType() {
+				t.Fatalf("got = %v, want %v", req.Header.Get("Content-Type"), tt.codec.ContentType())
+			}
+
+			got := tt.got()
+			if err = req.Decode(got); err != nil {
+				t.Fatal(err)
+			}
+			assertBodyEqual(t, got, tt.body)
+
+			resp, err := NewResponse("test", tt.body, WithCodec(tt.codec))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got = tt.got()
+			if err = resp.Decode(got); err != nil {
+				t.Fatal(err)
+			}
+			assertBodyEqual(t, got, tt.body)
+		})
+	}
+}
+
+// assertBodyEqual compares the decoded body, got is a pointer to it.
+func assertBodyEqual(t *testing.T, got, want any) {
+	t.Helper()
+
+	if m, ok := want.(proto.Message); ok {
+		if !proto.Equal(got.(proto.Message), m) {
+			t.Fatalf("got = %v, want %v", got, want)
+		}
+		return
+	}
+	if got = reflect.ValueOf(got).Elem().Interface(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got = %v, want %v", got, want)
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	t.Run("no content type", func(t *testing.T) {
+		c, err := CodecFor("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.ContentType() != "application/json" {
+			t.Fatalf("got = %v, want %v", c.ContentType(), "application/json")
+		}
+	})
+
+	t.Run("content type with parameters", func(t *testing.T) {
+		resp := Response{
+			Msg: &nats.Msg{
+				Header: nats.Header{
+					"Content-Type": []string{"Application/JSON; charset=utf-8"},
+				},
+				Data: []byte(`{"hello":"world"}`),
+			},
+		}
+
+		var got map[string]string
+		if err := resp.Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got["hello"] != "world" {
+			t.Fatalf("got = %v, want %v", got["hello"], "world")
+		}
+	})
+
+	t.Run("unsupported content type", func(t *testing.T) {
+		resp := Response{
+			Msg: &nats.Msg{
+				Header: nats.Header{
+					"Content-Type": []string{"application/xml"},
+				},
+				Data: []byte("<hello>world</hello>"),
+			},
+		}
+
+		var got map[string]string
+		err := resp.Decode(&got)
+		if CodeFromErr(err) != ErrorCodeInvalidArgument {
+			t.Fatalf("got = %v, want %v", CodeFromErr(err), ErrorCodeInvalidArgument)
+		}
+	})
+
+	t.Run("registered codec", func(t *testing.T) {
+		RegisterCodec(upperCodec{})
+
+		req, err := NewRequest("test", "hello", WithCodec(upperCodec{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got string
+		if err = req.Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != "HELLO" {
+			t.Fatalf("got = %v, want %v", got, "HELLO")
+		}
+	})
+}
+
+func TestRawCodec(t *testing.T) {
+	t.Run("string", func(t *testing.T) {
+		req, err := NewRequest("test", "hello", WithCodec(RawCodec{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got string
+		if err = req.Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != "hello" {
+			t.Fatalf("got = %v, want %v", got, "hello")
+		}
+	})
+
+	t.Run("invalid type", func(t *testing.T) {
+		_, err := NewRequest("test", map[string]string{"hello": "world"}, WithCodec(RawCodec{}))
+		if err == nil {
+			t.Fatal("expected error got nil")
+		}
+	})
+}
+
+func Test_negotiateCodec(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   string
+	}{
+		{
+			name:   "no accept header",
+			accept: "",
+			want:   "",
+		},
+		{
+			name:   "single content type",
+			accept: "application/msgpack",
+			want:   "application/msgpack",
+		},
+		{
+			name:   "first registered",
+			accept: "application/xml, application/protobuf+json, application/json",
+			want:   "application/protobuf+json",
+		},
+		{
+			name:   "quality values",
+			accept: "application/json;q=0.5, application/protobuf",
+			want:   "application/protobuf",
+		},
+		{
+			name:   "not acceptable",
+			accept: "application/protobuf;q=0, application/json",
+			want:   "application/json",
+		},
+		{
+			name:   "parameters",
+			accept: "application/json; charset=utf-8; q=0.5, application/msgpack; q=0.8",
+			want:   "application/msgpack",
+		},
+		{
+			name:   "any content type",
+			accept: "*/*",
+			want:   "",
+		},
+		{
+			name:   "unsupported",
+			accept: "application/xml",
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			if c, ok := negotiateCodec(tt.accept); ok {
+				got = c.ContentType()
+			}
+			if got != tt.want {
+				t.Fatalf("got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
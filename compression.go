@@ -0,0 +1,187 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/nats-io/nats.go"
+)
+
+// Content encodings supported by Compression.
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+const (
+	contentEncodingHeader = "Content-Encoding"
+	acceptEncodingHeader  = "Accept-Encoding"
+
+	defaultCompressionThreshold = 1024
+	// maxDecompressedSize limits the size of decompressed bodies to guard against decompression bombs.
+	maxDecompressedSize = 64 << 20
+)
+
+// Compression configures compressing request and response bodies, see WithCompression.
+// Compressed bodies are signalled with the Content-Encoding header and decompressed by Decode.
+type Compression struct {
+	// Encoding is the content encoding bodies are compressed with, either EncodingGzip or EncodingZstd.
+	Encoding string
+	// Threshold is the size in bytes below which bodies are sent uncompressed. It defaults to 1KiB.
+	Threshold int
+}
+
+func (c Compression) validate() error {
+	if !slices.Contains([]string{EncodingGzip, EncodingZstd}, c.Encoding) {
+		return Errorf(ErrorCodeInvalidArgument, "unsupported content encoding: %s", c.Encoding)
+	}
+
+	return nil
+}
+
+func (c Compression) threshold() int {
+	if c.Threshold <= 0 {
+		return defaultCompressionThreshold
+	}
+
+	return c.Threshold
+}
+
+// acceptEncoding returns the Accept-Encoding header of requests sent with the Compression,
+// listing the supported encodings with the configured one first.
+func (c Compression) acceptEncoding() string {
+	encodings := []string{c.Encoding}
+	for _, e := range []string{EncodingGzip, EncodingZstd} {
+		if e != c.Encoding {
+			encodings = append(encodings, e)
+		}
+	}
+
+	return strings.Join(encodings, ", ")
+}
+
+// compressMsg compresses the body of msg if it's at least as large as the threshold
+// and isn't compressed already.
+func (c Compression) compressMsg(msg *nats.Msg) error {
+	if len(msg.Data) < c.threshold() || msg.Header.Get(contentEncodingHeader) != "" {
+		return nil
+	}
+
+	data, err := compress(c.Encoding, msg.Data)
+	if err != nil {
+		return err
+	}
+
+	msg.Data = data
+	msg.Header.Set(contentEncodingHeader, c.Encoding)
+
+	return nil
+}
+
+// compressRequest returns a copy of the request with its body compressed,
+// asking the server to compress the response too.
+func (c Compression) compressRequest(r Request) (Request, error) {
+	r = cloneRequest(r)
+	r.Header.Set(acceptEncodingHeader, c.acceptEncoding())
+
+	return r, c.compressMsg(r.Msg)
+}
+
+// compressResponse compresses the body of the response to a request if the server was configured
+// with WithCompression and the caller accepts the server's encoding.
+func (s *Server) compressResponse(ctx context.Context, header nats.Header, resp *Response) {
+	if s.compression == nil || !acceptsEncoding(header.Get(acceptEncodingHeader), s.compression.Encoding) {
+		return
+	}
+
+	msg := &nats.Msg{Header: resp.Header, Data: resp.Data}
+	if err := s.compression.compressMsg(msg); err != nil {
+		// The response is sent uncompressed instead.
+		s.errorHandler(ctx, err)
+		return
+	}
+	resp.Data = msg.Data
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header lists the encoding.
+func acceptsEncoding(acceptEncoding string, encoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		e, _, _ := strings.Cut(part, ";")
+		if strings.TrimSpace(e) == encoding {
+			return true
+		}
+	}
+
+	return false
+}
+
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+		return zstd.NewWriter(nil)
+	})
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+		return zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize))
+	})
+)
+
+func compress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case EncodingGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case EncodingZstd:
+		enc, err := zstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(data, nil), nil
+	default:
+		return nil, Errorf(ErrorCodeInvalidArgument, "unsupported content encoding: %s", encoding)
+	}
+}
+
+// decompress decompresses a body according to its Content-Encoding header.
+func decompress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case "":
+		return data, nil
+	case EncodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		b, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > maxDecompressedSize {
+			return nil, fmt.Errorf("decompressed body exceeds %d bytes", maxDecompressedSize)
+		}
+		return b, nil
+	case EncodingZstd:
+		dec, err := zstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		return dec.DecodeAll(data, nil)
+	default:
+		return nil, Errorf(ErrorCodeInvalidArgument, "unsupported content encoding: %s", encoding)
+	}
+}
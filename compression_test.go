@@ -0,0 +1,246 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestCompression_compressMsg(t *testing.T) {
+	body := []byte(strings.Repeat("hello world ", 200))
+
+	tests := []struct {
+		name         string
+		compression  Compression
+		data         []byte
+		wantEncoding string
+	}{
+		{
+			name:         "gzip",
+			compression:  Compression{Encoding: EncodingGzip},
+			data:         body,
+			wantEncoding: EncodingGzip,
+		},
+		{
+			name:         "zstd",
+			compression:  Compression{Encoding: EncodingZstd},
+			data:         body,
+			wantEncoding: EncodingZstd,
+		},
+		{
+			name:         "below default threshold",
+			compression:  Compression{Encoding: EncodingGzip},
+			data:         []byte("hello world"),
+			wantEncoding: "",
+		},
+		{
+			name:         "custom threshold",
+			compression:  Compression{Encoding: EncodingGzip, Threshold: 5},
+			data:         []byte("hello world"),
+			wantEncoding: EncodingGzip,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &nats.Msg{Header: nats.Header{}, Data: tt.data}
+			if err := tt.compression.compressMsg(msg); err != nil {
+				t.Fatal(err)
+			}
+
+			encoding := msg.Header.Get("Content-Encoding")
+			if encoding != tt.wantEncoding {
+				t.Fatalf("got = %v, want %v", encoding, tt.wantEncoding)
+			}
+
+			got, err := decompress(encoding, msg.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Fatalf("got = %s, want %s", got, tt.data)
+			}
+		})
+	}
+}
+
+func Test_decompress(t *testing.T) {
+	t.Run("unsupported encoding", func(t *testing.T) {
+		_, err := decompress("br", []byte("hello"))
+		if CodeFromErr(err) != ErrorCodeInvalidArgument {
+			t.Fatalf("got = %v, want %v", CodeFromErr(err), ErrorCodeInvalidArgument)
+		}
+	})
+
+	t.Run("corrupt body", func(t *testing.T) {
+		_, err := decompress(EncodingGzip, []byte("hello"))
+		if err == nil {
+			t.Fatal("expected error got nil")
+		}
+	})
+}
+
+func Test_acceptsEncoding(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		encoding       string
+		want           bool
+	}{
+		{
+			name:           "empty",
+			acceptEncoding: "",
+			encoding:       EncodingGzip,
+			want:           false,
+		},
+		{
+			name:           "listed",
+			acceptEncoding: "zstd, gzip",
+			encoding:       EncodingGzip,
+			want:           true,
+		},
+		{
+			name:           "with params",
+			acceptEncoding: "gzip;q=0.5",
+			encoding:       EncodingGzip,
+			want:           true,
+		},
+		{
+			name:           "not listed",
+			acceptEncoding: "gzip",
+			encoding:       EncodingZstd,
+			want:           false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := acceptsEncoding(tt.acceptEncoding, tt.encoding); got != tt.want {
+				t.Fatalf("got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_DoCompression(t *testing.T) {
+	clientURL := startNatsServer(t)
+
+	srv, err := NewServer(&ServerConfig{
+		NatsURL: clientURL,
+		Name:    "test",
+	}, WithCompression(Compression{Encoding: EncodingZstd}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encodings := make(chan string, 1)
+	srv.Handle("echo", func(ctx context.Context, r Request) Response {
+		encodings <- r.Header.Get("Content-Encoding")
+
+		var body map[string]string
+		if err := r.Decode(&body); err != nil {
+			return NewErrorResponse(r.Reply, err)
+		}
+		resp, err := NewResponse(r.Reply, body)
+		if err != nil {
+			return NewErrorResponse(r.Reply, err)
+		}
+		return resp
+	})
+	go func() {
+		_ = srv.Run()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown(context.Background())
+	})
+
+	if !srv.ready(250 * time.Millisecond) {
+		t.Fatal("server not ready after 250 milliseconds")
+	}
+
+	client, err := NewClient(clientURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	large := map[string]string{"hello": strings.Repeat("world", 500)}
+	small := map[string]string{"hello": "world"}
+
+	tests := []struct {
+		name                 string
+		body                 map[string]string
+		opts                 []CallOption
+		wantRequestEncoding  string
+		wantResponseEncoding string
+	}{
+		{
+			name:                 "no compression",
+			body:                 large,
+			wantRequestEncoding:  "",
+			wantResponseEncoding: "",
+		},
+		{
+			name:                 "compressed",
+			body:                 large,
+			opts:                 []CallOption{WithCompression(Compression{Encoding: EncodingGzip})},
+			wantRequestEncoding:  EncodingGzip,
+			wantResponseEncoding: EncodingZstd,
+		},
+		{
+			name:                 "below threshold",
+			body:                 small,
+			opts:                 []CallOption{WithCompression(Compression{Encoding: EncodingGzip})},
+			wantRequestEncoding:  "",
+			wantResponseEncoding: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := mustNewRequest(t, "echo", tt.body)
+			data := req.Data
+
+			resp := client.Do(ctxWithTimeout(t, time.Second), req, tt.opts...)
+			if resp.Err != nil {
+				t.Fatal(resp.Err)
+			}
+
+			if got := <-encodings; got != tt.wantRequestEncoding {
+				t.Fatalf("request encoding got = %v, want %v", got, tt.wantRequestEncoding)
+			}
+			if got := resp.Header.Get("Content-Encoding"); got != tt.wantResponseEncoding {
+				t.Fatalf("response encoding got = %v, want %v", got, tt.wantResponseEncoding)
+			}
+			if !bytes.Equal(req.Data, data) {
+				t.Fatal("request body was modified")
+			}
+
+			var got map[string]string
+			if err := resp.Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got["hello"] != tt.body["hello"] {
+				t.Fatalf("got = %v, want %v", got["hello"], tt.body["hello"])
+			}
+		})
+	}
+
+	t.Run("unsupported encoding", func(t *testing.T) {
+		resp := client.Do(
+			ctxWithTimeout(t, time.Second),
+			mustNewRequest(t, "echo", small),
+			WithCompression(Compression{Encoding: "br"}),
+		)
+		if CodeFromErr(resp.Err) != ErrorCodeInvalidArgument {
+			t.Fatalf("got = %v, want %v", CodeFromErr(resp.Err), ErrorCodeInvalidArgument)
+		}
+
+		_, err := NewServer(&ServerConfig{NatsURL: clientURL}, WithCompression(Compression{Encoding: "br"}))
+		if CodeFromErr(err) != ErrorCodeInvalidArgument {
+			t.Fatalf("got = %v, want %v", CodeFromErr(err), ErrorCodeInvalidArgument)
+		}
+	})
+}
@@ -0,0 +1,196 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"sync"
+	"time"
+)
+
+// OverflowPolicy determines what happens to requests arriving while all of an endpoint's
+// workers are busy and its queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowReject responds to the request with an ErrorCodeResourceExhausted error.
+	OverflowReject OverflowPolicy = iota
+	// OverflowBlock waits for room in the queue, applying back-pressure to the endpoint's subscription.
+	OverflowBlock
+)
+
+// Concurrency configures how many requests an endpoint processes at the same time.
+// The zero value processes requests one at a time in the order they arrive.
+//
+// The micro stats of endpoints include ConcurrencyStats in their data field.
+type Concurrency struct {
+	// MaxInFlight is the maximum number of requests handled at the same time.
+	// A value of runtime.NumCPU() is a good starting point for CPU bound handlers.
+	MaxInFlight int
+	// QueueSize is the number of requests that can wait for a free worker before
+	// the OverflowPolicy is applied.
+	QueueSize int
+	// Overflow is the policy applied to requests arriving while the queue is full.
+	Overflow OverflowPolicy
+}
+
+func (c Concurrency) enabled() bool {
+	return c.MaxInFlight > 0
+}
+
+// ConcurrencyStats are reported in the data field of the micro stats of endpoints. Endpoints hand
+// requests off to other goroutines as soon as they arrive, so micro only measures the hand-off and
+// its processing time and error count don't reflect the handling of the request itself.
+type ConcurrencyStats struct {
+	NumRequests           int           `json:"num_requests"`
+	NumErrors             int           `json:"num_errors"`
+	NumRejected           int           `json:"num_rejected"`
+	LastError             string        `json:"last_error"`
+	ProcessingTime        time.Duration `json:"processing_time"`
+	AverageProcessingTime time.Duration `json:"average_processing_time"`
+}
+
+// workerPool runs jobs on a fixed number of goroutines.
+type workerPool struct {
+	mu       sync.RWMutex
+	jobs     chan func()
+	slots    chan struct{}
+	overflow OverflowPolicy
+	stopped  bool
+}
+
+func newWorkerPool(c Concurrency) *workerPool {
+	queueSize := c.QueueSize
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	// Every accepted job holds a slot until it has run, so at most
+	// MaxInFlight jobs are running and QueueSize jobs are waiting.
+	p := &workerPool{
+		jobs:     make(chan func(), c.MaxInFlight+queueSize),
+		slots:    make(chan struct{}, c.MaxInFlight+queueSize),
+		overflow: c.Overflow,
+	}
+
+	for i := 0; i < c.MaxInFlight; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+func (p *workerPool) work() {
+	for job := range p.jobs {
+		job()
+		<-p.slots
+	}
+}
+
+// submit schedules the job to be run by a worker. It reports false if the job
+// was rejected because the pool is full or stopped.
+func (p *workerPool) submit(job func()) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.stopped {
+		return false
+	}
+
+	if p.overflow == OverflowBlock {
+		p.slots <- struct{}{}
+	} else {
+		select {
+		case p.slots <- struct{}{}:
+		default:
+			return false
+		}
+	}
+
+	p.jobs <- job
+	return true
+}
+
+// stop stops accepting new jobs. Jobs that have already been submitted still run.
+func (p *workerPool) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return
+	}
+
+	p.stopped = true
+	close(p.jobs)
+}
+
+// fifo runs jobs one at a time in the order they were submitted. Unlike a workerPool with
+// a single worker it never rejects or blocks, so requests can always be handed off right away.
+type fifo struct {
+	mu      sync.Mutex
+	jobs    []func()
+	running bool
+}
+
+// submit schedules the job to run after the jobs submitted before it.
+func (q *fifo) submit(job func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.jobs = append(q.jobs, job)
+	if !q.running {
+		q.running = true
+		go q.work()
+	}
+}
+
+func (q *fifo) work() {
+	for {
+		q.mu.Lock()
+		if len(q.jobs) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		job := q.jobs[0]
+		q.jobs[0] = nil
+		q.jobs = q.jobs[1:]
+		q.mu.Unlock()
+
+		job()
+	}
+}
+
+// requestStats collects the ConcurrencyStats of an endpoint.
+type requestStats struct {
+	mu    sync.Mutex
+	stats ConcurrencyStats
+}
+
+// record adds a handled request to the stats.
+func (r *requestStats) record(start time.Time, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stats.NumRequests++
+	r.stats.ProcessingTime += time.Since(start)
+	r.stats.AverageProcessingTime = r.stats.ProcessingTime / time.Duration(r.stats.NumRequests)
+
+	if err != nil {
+		r.stats.NumErrors++
+		r.stats.LastError = err.Error()
+	}
+}
+
+// reject adds a rejected request to the stats.
+func (r *requestStats) reject() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stats.NumRejected++
+}
+
+func (r *requestStats) get() ConcurrencyStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.stats
+}
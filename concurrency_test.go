@@ -0,0 +1,217 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func Test_workerPool(t *testing.T) {
+	t.Run("runs jobs concurrently", func(t *testing.T) {
+		p := newWorkerPool(Concurrency{MaxInFlight: 2})
+		defer p.stop()
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		release := make(chan struct{})
+		for i := 0; i < 2; i++ {
+			ok := p.submit(func() {
+				wg.Done()
+				<-release
+			})
+			if !ok {
+				t.Fatal("expected job to be accepted")
+			}
+		}
+
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(1 * time.Second):
+			t.Fatal("expected both jobs to run at the same time")
+		}
+		close(release)
+	})
+
+	t.Run("rejects jobs when full", func(t *testing.T) {
+		p := newWorkerPool(Concurrency{MaxInFlight: 1, QueueSize: 1})
+		defer p.stop()
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		defer close(release)
+
+		if !p.submit(func() {
+			close(started)
+			<-release
+		}) {
+			t.Fatal("expected first job to be accepted")
+		}
+		<-started
+
+		if !p.submit(func() {}) {
+			t.Fatal("expected queued job to be accepted")
+		}
+		if p.submit(func() {}) {
+			t.Fatal("expected job to be rejected")
+		}
+	})
+
+	t.Run("rejects jobs when stopped", func(t *testing.T) {
+		p := newWorkerPool(Concurrency{MaxInFlight: 1, Overflow: OverflowBlock})
+		p.stop()
+
+		if p.submit(func() {}) {
+			t.Fatal("expected job to be rejected")
+		}
+	})
+}
+
+func Test_fifo(t *testing.T) {
+	var q fifo
+
+	release := make(chan struct{})
+	q.submit(func() {
+		<-release
+	})
+
+	var mu sync.Mutex
+	var got []int
+	done := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		// Submitting never blocks, even while a job is running.
+		q.submit(func() {
+			mu.Lock()
+			got = append(got, i)
+			mu.Unlock()
+			if i == 2 {
+				close(done)
+			}
+		})
+	}
+	close(release)
+
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Fatal("expected all jobs to run")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("got = %v, want jobs run in order", got)
+		}
+	}
+}
+
+func TestServer_Concurrency(t *testing.T) {
+	clientURL := startNatsServer(t)
+
+	srv, err := NewServer(&ServerConfig{
+		NatsURL: clientURL,
+		Name:    "test",
+	}, WithConcurrency(Concurrency{MaxInFlight: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	srv.Handle("slow", func(ctx context.Context, r Request) Response {
+		<-release
+		return Response{Msg: &nats.Msg{Subject: r.Reply}}
+	})
+	srv.Handle("fail", func(ctx context.Context, r Request) Response {
+		return NewErrorResponse(r.Reply, Errorf(ErrorCodeNotFound, "thingy not found"))
+	})
+
+	go func() {
+		_ = srv.Run()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown(context.Background())
+	})
+
+	if !srv.ready(250 * time.Millisecond) {
+		t.Fatal("server not ready after 250 milliseconds")
+	}
+
+	client, err := NewClient(clientURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	reqs := make([]Request, 3)
+	for i := range reqs {
+		reqs[i] = mustNewRequest(t, "slow", i)
+	}
+
+	ctx := ctxWithTimeout(t, 1*time.Second)
+	results := make(chan Response, len(reqs))
+	for _, r := range reqs {
+		go func(r Request) {
+			results <- client.Do(ctx, r)
+		}(r)
+	}
+
+	// Two requests are in flight so the third one is rejected.
+	select {
+	case resp := <-results:
+		if code := CodeFromErr(resp.Err); code != ErrorCodeResourceExhausted {
+			t.Fatalf("got = %v, want %v", code, ErrorCodeResourceExhausted)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("expected a request to be rejected")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	for i := 0; i < 2; i++ {
+		resp := <-results
+		if resp.Err != nil {
+			t.Fatalf("got = %v, want nil error", resp.Err)
+		}
+	}
+
+	resp := client.Do(ctx, mustNewRequest(t, "fail", 0))
+	if code := CodeFromErr(resp.Err); code != ErrorCodeNotFound {
+		t.Fatalf("got = %v, want %v", code, ErrorCodeNotFound)
+	}
+
+	stats := make(map[string]ConcurrencyStats)
+	for _, ep := range srv.svc.Stats().Endpoints {
+		var s ConcurrencyStats
+		if err = json.Unmarshal(ep.Data, &s); err != nil {
+			t.Fatal(err)
+		}
+		stats[ep.Subject] = s
+	}
+
+	if got := stats["slow"].NumRequests; got != 2 {
+		t.Errorf("got requests = %v, want %v", got, 2)
+	}
+	if got := stats["slow"].NumRejected; got != 1 {
+		t.Errorf("got rejected = %v, want %v", got, 1)
+	}
+	if got := stats["slow"].ProcessingTime; got < 20*time.Millisecond {
+		t.Errorf("got processing time = %v, want at least %v", got, 20*time.Millisecond)
+	}
+	if got := stats["fail"].NumErrors; got != 1 {
+		t.Errorf("got errors = %v, want %v", got, 1)
+	}
+	if got, want := stats["fail"].LastError, resp.Err.Error(); got != want {
+		t.Errorf("got last error = %v, want %v", got, want)
+	}
+}
@@ -3,6 +3,7 @@ package stormrpc
 
 import (
 	"context"
+	"sync"
 
 	"github.com/nats-io/nats.go"
 )
@@ -11,6 +12,9 @@ type ctxKey int
 
 const (
 	headerContextKey ctxKey = iota
+	serverContextKey
+	outgoingHeaderContextKey
+	responseHeaderContextKey
 )
 
 // HeadersFromContext retrieves RPC headers from the given context.
@@ -23,7 +27,94 @@ func HeadersFromContext(ctx context.Context) nats.Header {
 	return h
 }
 
+// AppendOutgoingHeader returns a copy of ctx with the header added to the headers sent with
+// requests made by a Client with the returned context.
+func AppendOutgoingHeader(ctx context.Context, key, value string) context.Context {
+	h := OutgoingHeadersFromContext(ctx)
+	h.Add(key, value)
+
+	return context.WithValue(ctx, outgoingHeaderContextKey, h)
+}
+
+// OutgoingHeadersFromContext retrieves the headers added to the given context with AppendOutgoingHeader.
+// The returned headers are a copy, so modifying them doesn't affect the context.
+func OutgoingHeadersFromContext(ctx context.Context) nats.Header {
+	h, _ := ctx.Value(outgoingHeaderContextKey).(nats.Header)
+
+	out := make(nats.Header, len(h))
+	for k, v := range h {
+		out[k] = append([]string(nil), v...)
+	}
+
+	return out
+}
+
+// responseHeaders holds the headers set with SetResponseHeader while a request is handled.
+type responseHeaders struct {
+	mu     sync.Mutex
+	header nats.Header
+}
+
+// SetResponseHeader sets a header of the response to the request being handled with ctx, replacing any
+// existing values of the key. It lets middleware and other code without access to the Response add headers.
+// Headers set with SetResponseHeader take precedence over the ones set on the Response by the HandlerFunc.
+// It has no effect if ctx isn't the context of a request handled by a Server.
+func SetResponseHeader(ctx context.Context, key, value string) {
+	rh, ok := ctx.Value(responseHeaderContextKey).(*responseHeaders)
+	if !ok {
+		return
+	}
+
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	rh.header.Set(key, value)
+}
+
+// AddResponseHeader adds a value to a header of the response to the request being handled with ctx,
+// see SetResponseHeader.
+func AddResponseHeader(ctx context.Context, key, value string) {
+	rh, ok := ctx.Value(responseHeaderContextKey).(*responseHeaders)
+	if !ok {
+		return
+	}
+
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	rh.header.Add(key, value)
+}
+
+// newContextWithResponseHeaders creates a new context that collects headers set with SetResponseHeader.
+func newContextWithResponseHeaders(ctx context.Context) context.Context {
+	return context.WithValue(ctx, responseHeaderContextKey, &responseHeaders{header: nats.Header{}})
+}
+
+// mergeResponseHeaders sets the headers collected in ctx on the header of a response.
+func mergeResponseHeaders(ctx context.Context, header nats.Header) {
+	rh, ok := ctx.Value(responseHeaderContextKey).(*responseHeaders)
+	if !ok {
+		return
+	}
+
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	for k, v := range rh.header {
+		header[k] = append([]string(nil), v...)
+	}
+}
+
 // newContextWithHeaders creates a new context with Header information stored in it.
 func newContextWithHeaders(ctx context.Context, headers nats.Header) context.Context {
 	return context.WithValue(ctx, headerContextKey, headers)
 }
+
+// newContextWithServer creates a new context with the server handling the request stored in it.
+// This allows stream handlers to exchange additional messages with the caller.
+func newContextWithServer(ctx context.Context, s *Server) context.Context {
+	return context.WithValue(ctx, serverContextKey, s)
+}
+
+// serverFromContext retrieves the server handling the request from the given context.
+func serverFromContext(ctx context.Context) *Server {
+	s, _ := ctx.Value(serverContextKey).(*Server)
+	return s
+}
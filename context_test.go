@@ -45,3 +45,74 @@ func TestHeadersFromContext(t *testing.T) {
 		})
 	}
 }
+
+func TestAppendOutgoingHeader(t *testing.T) {
+	ctx := AppendOutgoingHeader(context.Background(), "X-Tenant", "acme")
+	child := AppendOutgoingHeader(ctx, "X-Tenant", "globex")
+	child = AppendOutgoingHeader(child, "X-Caller", "billing")
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want nats.Header
+	}{
+		{
+			name: "no headers",
+			ctx:  context.Background(),
+			want: nats.Header{},
+		},
+		{
+			name: "single header",
+			ctx:  ctx,
+			want: nats.Header{"X-Tenant": []string{"acme"}},
+		},
+		{
+			name: "appended headers don't affect parent",
+			ctx:  child,
+			want: nats.Header{
+				"X-Tenant": []string{"acme", "globex"},
+				"X-Caller": []string{"billing"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := OutgoingHeadersFromContext(tt.ctx); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("OutgoingHeadersFromContext() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetResponseHeader(t *testing.T) {
+	t.Run("request context", func(t *testing.T) {
+		ctx := newContextWithResponseHeaders(context.Background())
+		SetResponseHeader(ctx, "Cache-Control", "no-store")
+		SetResponseHeader(ctx, "Cache-Control", "max-age=60")
+		AddResponseHeader(ctx, "Warning", "a")
+		AddResponseHeader(ctx, "Warning", "b")
+
+		header := nats.Header{"Cache-Control": []string{"private"}, "X-Other": []string{"true"}}
+		mergeResponseHeaders(ctx, header)
+
+		want := nats.Header{
+			"Cache-Control": []string{"max-age=60"},
+			"Warning":       []string{"a", "b"},
+			"X-Other":       []string{"true"},
+		}
+		if !reflect.DeepEqual(header, want) {
+			t.Errorf("mergeResponseHeaders() = %v, want %v", header, want)
+		}
+	})
+
+	t.Run("no request context", func(t *testing.T) {
+		ctx := context.Background()
+		SetResponseHeader(ctx, "Cache-Control", "no-store")
+
+		header := nats.Header{}
+		mergeResponseHeaders(ctx, header)
+		if len(header) != 0 {
+			t.Errorf("mergeResponseHeaders() = %v, want %v", header, nats.Header{})
+		}
+	})
+}
@@ -0,0 +1,272 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nats.go/micro"
+)
+
+const (
+	defaultWorkQueueMaxDeliver = 5
+	defaultWorkQueueBackoff    = 1 * time.Second
+	defaultWorkQueueMaxBackoff = 30 * time.Second
+)
+
+// WorkQueue configures an endpoint to consume requests from a JetStream work queue stream instead of
+// subscribing with core NATS, so requests sent while no server is running aren't lost.
+// Requests to the endpoint must be sent with the WithDurable CallOption.
+//
+// Requests are acknowledged once handled successfully. Requests failing with a retryable error are
+// redelivered with exponential backoff until MaxDeliver is reached, requests failing with any other
+// error aren't redelivered. Replies are only sent once a request won't be redelivered anymore,
+// and only while the client is still waiting for it.
+type WorkQueue struct {
+	// Stream is the name of the JetStream stream requests are stored in. If the stream doesn't exist
+	// it's created with work queue retention and the endpoint's subject when the server starts.
+	Stream string
+	// MaxDeliver is the maximum number of times a request is delivered. It defaults to 5.
+	MaxDeliver int
+	// Backoff is the delay before a request is redelivered for the first time. It defaults to 1s
+	// and doubles with every delivery.
+	Backoff time.Duration
+	// MaxBackoff caps the delay between deliveries. It defaults to 30s.
+	MaxBackoff time.Duration
+	// RetryableCodes are the error codes requests are redelivered on. It defaults to ErrorCodeUnavailable,
+	// ErrorCodeResourceExhausted, and ErrorCodeAborted.
+	RetryableCodes []ErrorCode
+}
+
+func (wq *WorkQueue) setDefaults() {
+	if wq.MaxDeliver <= 0 {
+		wq.MaxDeliver = defaultWorkQueueMaxDeliver
+	}
+	if wq.Backoff <= 0 {
+		wq.Backoff = defaultWorkQueueBackoff
+	}
+	if wq.MaxBackoff <= 0 {
+		wq.MaxBackoff = defaultWorkQueueMaxBackoff
+	}
+	if len(wq.RetryableCodes) == 0 {
+		wq.RetryableCodes = []ErrorCode{ErrorCodeUnavailable, ErrorCodeResourceExhausted, ErrorCodeAborted}
+	}
+}
+
+func (wq *WorkQueue) backoff(delivered uint64) time.Duration {
+	d := float64(wq.Backoff) * math.Pow(2, float64(delivered-1))
+	return time.Duration(math.Min(d, float64(wq.MaxBackoff)))
+}
+
+// createWorkQueueConsumer consumes the requests to a subject configured with WithWorkQueue.
+// Must be called with s.mu held.
+func (s *Server) createWorkQueueConsumer(subject string, handlerFunc HandlerFunc) error {
+	opts := s.endpoints[subject]
+	wq := *opts.workQueue
+	wq.setDefaults()
+
+	timeout := s.timeout
+	if opts.timeout > 0 {
+		timeout = opts.timeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultServerTimeout)
+	defer cancel()
+
+	js, err := jetstream.New(s.nc)
+	if err != nil {
+		return err
+	}
+
+	stream, err := js.Stream(ctx, wq.Stream)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		stream, err = js.CreateStream(ctx, jetstream.StreamConfig{
+			Name:      wq.Stream,
+			Subjects:  []string{subject},
+			Retention: jetstream.WorkQueuePolicy,
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	consumer, err := stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:       nameFromSubject(subject),
+		FilterSubject: subject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		MaxDeliver:    wq.MaxDeliver,
+	})
+	if err != nil {
+		return err
+	}
+
+	cc, err := consumer.Consume(func(msg jetstream.Msg) {
+		s.accept()
+		defer s.finish()
+
+		s.handleWorkQueueMsg(msg, handlerFunc, wq, timeout)
+	})
+	if err != nil {
+		return err
+	}
+	s.consumers = append(s.consumers, cc)
+
+	return nil
+}
+
+func (s *Server) handleWorkQueueMsg(msg jetstream.Msg, handlerFunc HandlerFunc, wq WorkQueue, timeout time.Duration) {
+	header := msg.Headers()
+	if header == nil {
+		header = nats.Header{}
+	}
+
+	// The client's deadline only limits how long it waits for the reply,
+	// the request is processed regardless.
+	ctx, cancel := s.newRequestContext(context.Background(), header, time.Time{}, timeout)
+	defer cancel()
+
+	resp := handlerFunc(ctx, Request{
+		Msg: &nats.Msg{
+			Subject: msg.Subject(),
+			Reply:   header.Get(replyToHeader),
+			Header:  header,
+			Data:    msg.Data(),
+		},
+		payloads: s.payloads,
+	})
+
+	var ackErr error
+	switch {
+	case resp.Err == nil:
+		ackErr = msg.Ack()
+	case slices.Contains(wq.RetryableCodes, CodeFromErr(resp.Err)):
+		md, err := msg.Metadata()
+		if err == nil && md.NumDelivered < uint64(wq.MaxDeliver) {
+			if err = msg.NakWithDelay(wq.backoff(md.NumDelivered)); err != nil {
+				s.errorHandler(ctx, err)
+			}
+			return
+		}
+		ackErr = msg.Term()
+	default:
+		ackErr = msg.Term()
+	}
+	if ackErr != nil {
+		s.errorHandler(ctx, ackErr)
+	}
+
+	s.replyWorkQueueMsg(ctx, header, resp)
+}
+
+// replyWorkQueueMsg sends the Response to the caller of a work queue request if it's still waiting.
+func (s *Server) replyWorkQueueMsg(ctx context.Context, header nats.Header, resp Response) {
+	reply := header.Get(replyToHeader)
+	if reply == "" {
+		return
+	}
+	if dl := parseDeadlineHeader(header); !dl.IsZero() && time.Now().After(dl) {
+		return
+	}
+
+	msg := &nats.Msg{Subject: reply, Header: nats.Header{}}
+	if resp.Msg != nil {
+		msg.Data = resp.Data
+		for k, v := range resp.Header {
+			msg.Header[k] = v
+		}
+	}
+	mergeResponseHeaders(ctx, msg.Header)
+	if s.id != "" {
+		msg.Header.Set(instanceIDHeader, s.id)
+	}
+	s.compressResponse(ctx, header, &Response{Msg: msg})
+	if _, err := s.payloads.store(ctx, msg); err != nil {
+		s.errorHandler(ctx, err)
+		msg.Data = nil
+		resp.Err = err
+	}
+	if resp.Err != nil {
+		code, description := serviceError(resp.Err)
+		msg.Header.Set(micro.ErrorHeader, description)
+		msg.Header.Set(micro.ErrorCodeHeader, code)
+		setErrorHeader(msg.Header, resp.Err, s.legacyErrors)
+	}
+
+	if err := s.nc.PublishMsg(msg); err != nil {
+		s.errorHandler(ctx, err)
+	}
+}
+
+// stopConsumers stops consuming work queue requests and waits for the requests that were
+// already received to be handled, or until ctx is done.
+func (s *Server) stopConsumers(ctx context.Context) {
+	s.mu.RLock()
+	consumers := s.consumers
+	s.mu.RUnlock()
+
+	for _, cc := range consumers {
+		cc.Drain()
+	}
+
+	for _, cc := range consumers {
+		select {
+		case <-cc.Closed():
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendDurable completes a single attempt of a request sent with WithDurable.
+func (c *Client) sendDurable(ctx context.Context, r Request) Response {
+	dl, ok := ctx.Deadline()
+	if ok {
+		setDeadlineHeader(r.Header, dl)
+	}
+
+	sub, err := c.nc.SubscribeSync(c.nc.NewRespInbox())
+	if err != nil {
+		return NewErrorResponse("", err)
+	}
+	defer func() {
+		_ = sub.Unsubscribe()
+	}()
+
+	req := cloneRequest(r)
+	req.Header.Set(replyToHeader, sub.Subject)
+
+	js, err := jetstream.New(c.nc)
+	if err != nil {
+		return NewErrorResponse("", err)
+	}
+
+	if _, err = js.PublishMsg(ctx, req.Msg); err != nil {
+		return NewErrorResponse("", requestError(err, r.Subject()))
+	}
+
+	msg, err := sub.NextMsgWithContext(ctx)
+	if err != nil {
+		return Response{
+			Msg: msg,
+			Err: requestError(err, r.Subject()),
+		}
+	}
+
+	rpcErr := parseErrorHeader(msg.Header)
+	if rpcErr != nil {
+		return Response{
+			Msg: msg,
+			Err: rpcErr,
+		}
+	}
+
+	return Response{
+		Msg: msg,
+		Err: nil,
+	}
+}
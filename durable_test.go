@@ -0,0 +1,199 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestServer_WorkQueue(t *testing.T) {
+	clientURL := startJetStreamServer(t)
+
+	client, err := NewClient(clientURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	newServer := func(t *testing.T) *Server {
+		t.Helper()
+
+		srv, err := NewServer(&ServerConfig{
+			NatsURL: clientURL,
+			Name:    "test",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return srv
+	}
+
+	runServer := func(t *testing.T, srv *Server) {
+		t.Helper()
+
+		go func() {
+			_ = srv.Run()
+		}()
+		t.Cleanup(func() {
+			_ = srv.Shutdown(context.Background())
+		})
+
+		if !srv.ready(time.Second) {
+			t.Fatal("server not ready after 1 second")
+		}
+	}
+
+	t.Run("request sent before server starts", func(t *testing.T) {
+		wq := WorkQueue{Stream: "ORDERS"}
+
+		// The first server creates the stream.
+		srv := newServer(t)
+		srv.Handle("orders.create", func(ctx context.Context, r Request) Response {
+			return NewErrorResponse(r.Reply, Errorf(ErrorCodeInternal, "unexpected request"))
+		}, WithWorkQueue(wq))
+		go func() {
+			_ = srv.Run()
+		}()
+		if !srv.ready(time.Second) {
+			t.Fatal("server not ready after 1 second")
+		}
+		if err := srv.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		req := mustNewRequest(t, "orders.create", map[string]string{"id": "1"})
+		ctx := ctxWithTimeout(t, 5*time.Second)
+		result := make(chan Response, 1)
+		go func() {
+			result <- client.Do(ctx, req, WithDurable())
+		}()
+
+		time.Sleep(100 * time.Millisecond)
+
+		srv = newServer(t)
+		srv.Handle("orders.create", func(ctx context.Context, r Request) Response {
+			var body map[string]string
+			if err := r.Decode(&body); err != nil {
+				return NewErrorResponse(r.Reply, err)
+			}
+			resp, err := NewResponse(r.Reply, map[string]string{"created": body["id"]})
+			if err != nil {
+				return NewErrorResponse(r.Reply, err)
+			}
+			return resp
+		}, WithWorkQueue(wq))
+		runServer(t, srv)
+
+		resp := <-result
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+
+		var body map[string]string
+		if err := resp.Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body["created"] != "1" {
+			t.Fatalf("got = %v, want %v", body["created"], "1")
+		}
+	})
+
+	t.Run("retryable error is redelivered", func(t *testing.T) {
+		var deliveries atomic.Int64
+		srv := newServer(t)
+		srv.Handle("jobs.flaky", func(ctx context.Context, r Request) Response {
+			if deliveries.Add(1) < 3 {
+				return NewErrorResponse(r.Reply, Errorf(ErrorCodeUnavailable, "try again"))
+			}
+			resp, _ := NewResponse(r.Reply, map[string]string{"hello": "world"})
+			return resp
+		}, WithWorkQueue(WorkQueue{Stream: "FLAKY", Backoff: 10 * time.Millisecond}))
+		runServer(t, srv)
+
+		resp := client.Do(ctxWithTimeout(t, 5*time.Second), mustNewRequest(t, "jobs.flaky", nil), WithDurable())
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+
+		if got := deliveries.Load(); got != 3 {
+			t.Fatalf("got = %v, want %v", got, 3)
+		}
+	})
+
+	t.Run("max deliveries reached", func(t *testing.T) {
+		var deliveries atomic.Int64
+		srv := newServer(t)
+		srv.Handle("jobs.unavailable", func(ctx context.Context, r Request) Response {
+			deliveries.Add(1)
+			return NewErrorResponse(r.Reply, Errorf(ErrorCodeUnavailable, "try again"))
+		}, WithWorkQueue(WorkQueue{Stream: "UNAVAILABLE", MaxDeliver: 2, Backoff: 10 * time.Millisecond}))
+		runServer(t, srv)
+
+		resp := client.Do(ctxWithTimeout(t, 5*time.Second), mustNewRequest(t, "jobs.unavailable", nil), WithDurable())
+		if CodeFromErr(resp.Err) != ErrorCodeUnavailable {
+			t.Fatalf("got = %v, want %v", CodeFromErr(resp.Err), ErrorCodeUnavailable)
+		}
+
+		if got := deliveries.Load(); got != 2 {
+			t.Fatalf("got = %v, want %v", got, 2)
+		}
+	})
+
+	t.Run("error is not redelivered", func(t *testing.T) {
+		var deliveries atomic.Int64
+		srv := newServer(t)
+		srv.Handle("jobs.invalid", func(ctx context.Context, r Request) Response {
+			deliveries.Add(1)
+			return NewErrorResponse(r.Reply, Errorf(ErrorCodeInvalidArgument, "invalid job"))
+		}, WithWorkQueue(WorkQueue{Stream: "INVALID", Backoff: 10 * time.Millisecond}))
+		runServer(t, srv)
+
+		resp := client.Do(ctxWithTimeout(t, 5*time.Second), mustNewRequest(t, "jobs.invalid", nil), WithDurable())
+		if CodeFromErr(resp.Err) != ErrorCodeInvalidArgument {
+			t.Fatalf("got = %v, want %v", CodeFromErr(resp.Err), ErrorCodeInvalidArgument)
+		}
+		if MessageFromErr(resp.Err) != "invalid job" {
+			t.Fatalf("got = %v, want %v", MessageFromErr(resp.Err), "invalid job")
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		if got := deliveries.Load(); got != 1 {
+			t.Fatalf("got = %v, want %v", got, 1)
+		}
+	})
+
+	t.Run("response headers", func(t *testing.T) {
+		srv := newServer(t)
+		srv.Handle("jobs.headers", func(ctx context.Context, r Request) Response {
+			SetResponseHeader(ctx, "X-Job", "done")
+			return Response{Msg: &nats.Msg{Subject: r.Reply}}
+		}, WithWorkQueue(WorkQueue{Stream: "HEADERS"}))
+		runServer(t, srv)
+
+		header := nats.Header{}
+		resp := client.Do(
+			ctxWithTimeout(t, 5*time.Second),
+			mustNewRequest(t, "jobs.headers", nil),
+			WithDurable(),
+			WithResponseHeader(header),
+		)
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+		if got := header.Get("X-Job"); got != "done" {
+			t.Fatalf("got = %v, want %v", got, "done")
+		}
+	})
+
+	t.Run("no stream", func(t *testing.T) {
+		resp := client.Do(ctxWithTimeout(t, 5*time.Second), mustNewRequest(t, "jobs.nowhere", nil), WithDurable())
+		if CodeFromErr(resp.Err) != ErrorCodeUnavailable {
+			t.Fatalf("got = %v, want %v", CodeFromErr(resp.Err), ErrorCodeUnavailable)
+		}
+	})
+}
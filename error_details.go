@@ -0,0 +1,183 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ErrorDetail is implemented by types that carry additional, structured information about an Error.
+// Custom detail types must be registered with RegisterErrorDetail so clients can decode them.
+type ErrorDetail interface {
+	// ErrorDetailType returns the name the detail is identified by on the wire.
+	ErrorDetailType() string
+}
+
+// FieldViolation describes a single invalid field of a request.
+type FieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+// ErrorDetailType implements ErrorDetail.
+func (FieldViolation) ErrorDetailType() string {
+	return "stormrpc.FieldViolation"
+}
+
+// RetryInfo tells the client how long to wait before retrying a request.
+type RetryInfo struct {
+	RetryDelay time.Duration `json:"retry_delay"`
+}
+
+// ErrorDetailType implements ErrorDetail.
+func (RetryInfo) ErrorDetailType() string {
+	return "stormrpc.RetryInfo"
+}
+
+// DebugInfo carries information useful for debugging a failed request, such as a stack trace.
+type DebugInfo struct {
+	StackEntries []string `json:"stack_entries,omitempty"`
+	Detail       string   `json:"detail,omitempty"`
+}
+
+// ErrorDetailType implements ErrorDetail.
+func (DebugInfo) ErrorDetailType() string {
+	return "stormrpc.DebugInfo"
+}
+
+// UnknownErrorDetail holds a received detail whose type wasn't registered with RegisterErrorDetail.
+type UnknownErrorDetail struct {
+	Type  string
+	Value json.RawMessage
+}
+
+// ErrorDetailType implements ErrorDetail.
+func (d UnknownErrorDetail) ErrorDetailType() string {
+	return d.Type
+}
+
+var (
+	errorDetailsMu   sync.RWMutex
+	errorDetailTypes = map[string]reflect.Type{
+		FieldViolation{}.ErrorDetailType(): reflect.TypeOf(FieldViolation{}),
+		RetryInfo{}.ErrorDetailType():      reflect.TypeOf(RetryInfo{}),
+		DebugInfo{}.ErrorDetailType():      reflect.TypeOf(DebugInfo{}),
+	}
+)
+
+// RegisterErrorDetail registers a custom ErrorDetail type so it can be decoded from errors received
+// by a Client. Details of unregistered types are decoded as UnknownErrorDetail.
+func RegisterErrorDetail(d ErrorDetail) {
+	typ := reflect.TypeOf(d)
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	errorDetailsMu.Lock()
+	defer errorDetailsMu.Unlock()
+
+	errorDetailTypes[d.ErrorDetailType()] = typ
+}
+
+// errorStatus is the wire format of an Error.
+type errorStatus struct {
+	Code     string               `json:"code"`
+	Message  string               `json:"message"`
+	Details  []encodedErrorDetail `json:"details,omitempty"`
+	Metadata map[string]string    `json:"metadata,omitempty"`
+}
+
+type encodedErrorDetail struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// MarshalError encodes err, including its details and metadata, in the format errors are sent with.
+// If the error is not of type Error, it's encoded with ErrorCodeUnknown and the message "unknown error".
+func MarshalError(err error) ([]byte, error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = &Error{Code: CodeFromErr(err), Message: MessageFromErr(err)}
+	}
+
+	status, err := encodeErrorStatus(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(status), nil
+}
+
+// UnmarshalError decodes an error encoded with MarshalError.
+// Details of types that weren't registered with RegisterErrorDetail are decoded as UnknownErrorDetail.
+func UnmarshalError(data []byte) (*Error, error) {
+	return decodeErrorStatus(string(data))
+}
+
+func encodeErrorStatus(e *Error) (string, error) {
+	status := errorStatus{
+		Code:     e.Code.String(),
+		Message:  e.Message,
+		Metadata: e.Metadata,
+	}
+
+	for _, d := range e.Details {
+		if u, ok := d.(UnknownErrorDetail); ok {
+			status.Details = append(status.Details, encodedErrorDetail{Type: u.Type, Value: u.Value})
+			continue
+		}
+
+		v, err := json.Marshal(d)
+		if err != nil {
+			return "", err
+		}
+		status.Details = append(status.Details, encodedErrorDetail{Type: d.ErrorDetailType(), Value: v})
+	}
+
+	b, err := json.Marshal(status)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func decodeErrorStatus(s string) (*Error, error) {
+	var status errorStatus
+	if err := json.Unmarshal([]byte(s), &status); err != nil {
+		return nil, err
+	}
+
+	e := &Error{
+		Code:     codeFromString(status.Code),
+		Message:  status.Message,
+		Metadata: status.Metadata,
+	}
+
+	errorDetailsMu.RLock()
+	defer errorDetailsMu.RUnlock()
+
+	for _, d := range status.Details {
+		typ, ok := errorDetailTypes[d.Type]
+		if !ok {
+			e.Details = append(e.Details, UnknownErrorDetail{Type: d.Type, Value: d.Value})
+			continue
+		}
+
+		v := reflect.New(typ)
+		if err := json.Unmarshal(d.Value, v.Interface()); err != nil {
+			return nil, err
+		}
+
+		detail, ok := v.Elem().Interface().(ErrorDetail)
+		if !ok {
+			detail = UnknownErrorDetail{Type: d.Type, Value: d.Value}
+		}
+		e.Details = append(e.Details, detail)
+	}
+
+	return e, nil
+}
@@ -0,0 +1,126 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type quotaViolation struct {
+	Subject string `json:"subject"`
+	Limit   int    `json:"limit"`
+}
+
+func (quotaViolation) ErrorDetailType() string {
+	return "test.QuotaViolation"
+}
+
+func Test_encodeErrorStatus(t *testing.T) {
+	RegisterErrorDetail(&quotaViolation{})
+
+	tests := []struct {
+		name string
+		err  *Error
+		want *Error
+	}{
+		{
+			name: "no details",
+			err:  Errorf(ErrorCodeNotFound, "thingy not found"),
+			want: &Error{Code: ErrorCodeNotFound, Message: "thingy not found"},
+		},
+		{
+			name: "builtin details",
+			err: Errorf(ErrorCodeInternal, "try again: later").WithDetails(
+				RetryInfo{RetryDelay: 2 * time.Second},
+				DebugInfo{StackEntries: []string{"main.go:12"}, Detail: "boom"},
+			),
+			want: &Error{
+				Code:    ErrorCodeInternal,
+				Message: "try again: later",
+				Details: []ErrorDetail{
+					RetryInfo{RetryDelay: 2 * time.Second},
+					DebugInfo{StackEntries: []string{"main.go:12"}, Detail: "boom"},
+				},
+				Metadata: map[string]string{},
+			},
+		},
+		{
+			name: "registered and unknown details",
+			err: Errorf(ErrorCodeResourceExhausted, "quota exceeded").WithDetails(
+				quotaViolation{Subject: "orders", Limit: 10},
+				UnknownErrorDetail{Type: "other.Detail", Value: json.RawMessage(`{"a":1}`)},
+			).WithMetadata("tenant", "acme"),
+			want: &Error{
+				Code:    ErrorCodeResourceExhausted,
+				Message: "quota exceeded",
+				Details: []ErrorDetail{
+					quotaViolation{Subject: "orders", Limit: 10},
+					UnknownErrorDetail{Type: "other.Detail", Value: json.RawMessage(`{"a":1}`)},
+				},
+				Metadata: map[string]string{"tenant": "acme"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := encodeErrorStatus(tt.err)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := decodeErrorStatus(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(tt.want.Metadata) == 0 {
+				got.Metadata, tt.want.Metadata = nil, nil
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeErrorStatus() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarshalError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want *Error
+	}{
+		{
+			name: "error with details",
+			err:  Errorf(ErrorCodeInvalidArgument, "bad id").WithDetails(FieldViolation{Field: "id", Description: "required"}),
+			want: &Error{
+				Code:    ErrorCodeInvalidArgument,
+				Message: "bad id",
+				Details: []ErrorDetail{FieldViolation{Field: "id", Description: "required"}},
+			},
+		},
+		{
+			name: "plain error",
+			err:  errors.New("boom"),
+			want: &Error{Code: ErrorCodeUnknown, Message: "unknown error"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := MarshalError(tt.err)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := UnmarshalError(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UnmarshalError() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import "net/http"
+
+// grpcCodes maps ErrorCodes to their gRPC status code equivalents.
+// See https://grpc.github.io/grpc/core/md_doc_statuscodes.html.
+var grpcCodes = map[ErrorCode]uint32{
+	ErrorCodeCanceled:           1,
+	ErrorCodeUnknown:            2,
+	ErrorCodeInvalidArgument:    3,
+	ErrorCodeDeadlineExceeded:   4,
+	ErrorCodeNotFound:           5,
+	ErrorCodeAlreadyExists:      6,
+	ErrorCodePermissionDenied:   7,
+	ErrorCodeResourceExhausted:  8,
+	ErrorCodeFailedPrecondition: 9,
+	ErrorCodeAborted:            10,
+	ErrorCodeOutOfRange:         11,
+	ErrorCodeUnimplemented:      12,
+	ErrorCodeInternal:           13,
+	ErrorCodeUnavailable:        14,
+	ErrorCodeDataLoss:           15,
+	ErrorCodeUnauthenticated:    16,
+}
+
+// GRPCCode returns the gRPC status code equivalent to the ErrorCode.
+func (c ErrorCode) GRPCCode() uint32 {
+	if code, ok := grpcCodes[c]; ok {
+		return code
+	}
+	return grpcCodes[ErrorCodeUnknown]
+}
+
+// CodeFromGRPCCode returns the ErrorCode equivalent to the given gRPC status code.
+// ErrorCodeUnknown is returned for OK and unknown codes.
+func CodeFromGRPCCode(code uint32) ErrorCode {
+	for c, grpcCode := range grpcCodes {
+		if grpcCode == code {
+			return c
+		}
+	}
+	return ErrorCodeUnknown
+}
+
+// HTTPStatus returns the HTTP status code best describing the ErrorCode.
+func (c ErrorCode) HTTPStatus() int {
+	switch c {
+	case ErrorCodeCanceled:
+		return 499 // Client Closed Request
+	case ErrorCodeInvalidArgument, ErrorCodeFailedPrecondition, ErrorCodeOutOfRange:
+		return http.StatusBadRequest
+	case ErrorCodeDeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case ErrorCodeNotFound:
+		return http.StatusNotFound
+	case ErrorCodeAlreadyExists, ErrorCodeAborted:
+		return http.StatusConflict
+	case ErrorCodePermissionDenied:
+		return http.StatusForbidden
+	case ErrorCodeUnauthenticated:
+		return http.StatusUnauthorized
+	case ErrorCodeResourceExhausted:
+		return http.StatusTooManyRequests
+	case ErrorCodeUnimplemented:
+		return http.StatusNotImplemented
+	case ErrorCodeUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// CodeFromHTTPStatus returns the ErrorCode best describing the given HTTP status code.
+// ErrorCodeUnknown is returned for status codes that don't describe an error.
+func CodeFromHTTPStatus(status int) ErrorCode {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrorCodeInvalidArgument
+	case http.StatusUnauthorized:
+		return ErrorCodeUnauthenticated
+	case http.StatusForbidden:
+		return ErrorCodePermissionDenied
+	case http.StatusNotFound:
+		return ErrorCodeNotFound
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return ErrorCodeDeadlineExceeded
+	case http.StatusConflict:
+		return ErrorCodeAlreadyExists
+	case http.StatusPreconditionFailed:
+		return ErrorCodeFailedPrecondition
+	case http.StatusRequestedRangeNotSatisfiable:
+		return ErrorCodeOutOfRange
+	case http.StatusTooManyRequests:
+		return ErrorCodeResourceExhausted
+	case 499:
+		return ErrorCodeCanceled
+	case http.StatusNotImplemented:
+		return ErrorCodeUnimplemented
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return ErrorCodeUnavailable
+	}
+
+	if status >= http.StatusInternalServerError && status < 600 {
+		return ErrorCodeInternal
+	}
+	return ErrorCodeUnknown
+}
@@ -0,0 +1,93 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestErrorCode_GRPCCode(t *testing.T) {
+	tests := []struct {
+		name string
+		c    ErrorCode
+		want uint32
+	}{
+		{name: "unknown", c: ErrorCodeUnknown, want: 2},
+		{name: "not found", c: ErrorCodeNotFound, want: 5},
+		{name: "resource exhausted", c: ErrorCodeResourceExhausted, want: 8},
+		{name: "unavailable", c: ErrorCodeUnavailable, want: 14},
+		{name: "unauthenticated", c: ErrorCodeUnauthenticated, want: 16},
+		{name: "default", c: 10000, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.c.GRPCCode(); got != tt.want {
+				t.Errorf("GRPCCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		for c := ErrorCodeUnknown; c <= ErrorCodeDataLoss; c++ {
+			if got := CodeFromGRPCCode(c.GRPCCode()); got != c {
+				t.Errorf("CodeFromGRPCCode(%v) = %v, want %v", c.GRPCCode(), got, c)
+			}
+		}
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		if got := CodeFromGRPCCode(0); got != ErrorCodeUnknown {
+			t.Errorf("CodeFromGRPCCode() = %v, want %v", got, ErrorCodeUnknown)
+		}
+	})
+}
+
+func TestErrorCode_HTTPStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		c    ErrorCode
+		want int
+	}{
+		{name: "unknown", c: ErrorCodeUnknown, want: http.StatusInternalServerError},
+		{name: "canceled", c: ErrorCodeCanceled, want: 499},
+		{name: "invalid argument", c: ErrorCodeInvalidArgument, want: http.StatusBadRequest},
+		{name: "failed precondition", c: ErrorCodeFailedPrecondition, want: http.StatusBadRequest},
+		{name: "not found", c: ErrorCodeNotFound, want: http.StatusNotFound},
+		{name: "aborted", c: ErrorCodeAborted, want: http.StatusConflict},
+		{name: "resource exhausted", c: ErrorCodeResourceExhausted, want: http.StatusTooManyRequests},
+		{name: "unavailable", c: ErrorCodeUnavailable, want: http.StatusServiceUnavailable},
+		{name: "deadline exceeded", c: ErrorCodeDeadlineExceeded, want: http.StatusGatewayTimeout},
+		{name: "data loss", c: ErrorCodeDataLoss, want: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.c.HTTPStatus(); got != tt.want {
+				t.Errorf("HTTPStatus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCodeFromHTTPStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		want   ErrorCode
+	}{
+		{name: "ok", status: http.StatusOK, want: ErrorCodeUnknown},
+		{name: "bad request", status: http.StatusBadRequest, want: ErrorCodeInvalidArgument},
+		{name: "precondition failed", status: http.StatusPreconditionFailed, want: ErrorCodeFailedPrecondition},
+		{name: "client closed request", status: 499, want: ErrorCodeCanceled},
+		{name: "teapot", status: http.StatusTeapot, want: ErrorCodeUnknown},
+		{name: "bad gateway", status: http.StatusBadGateway, want: ErrorCodeUnavailable},
+		{name: "gateway timeout", status: http.StatusGatewayTimeout, want: ErrorCodeDeadlineExceeded},
+		{name: "other server error", status: 507, want: ErrorCodeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CodeFromHTTPStatus(tt.status); got != tt.want {
+				t.Errorf("CodeFromHTTPStatus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
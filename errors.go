@@ -11,15 +11,22 @@ type ErrorCode int
 
 // RPC ErrorCodes.
 const (
-	ErrorCodeUnknown          ErrorCode = 0
-	ErrorCodeInternal         ErrorCode = 1
-	ErrorCodeNotFound         ErrorCode = 2
-	ErrorCodeInvalidArgument  ErrorCode = 3
-	ErrorCodeUnimplemented    ErrorCode = 4
-	ErrorCodeUnauthenticated  ErrorCode = 5
-	ErrorCodePermissionDenied ErrorCode = 6
-	ErrorCodeAlreadyExists    ErrorCode = 7
-	ErrorCodeDeadlineExceeded ErrorCode = 8
+	ErrorCodeUnknown            ErrorCode = 0
+	ErrorCodeInternal           ErrorCode = 1
+	ErrorCodeNotFound           ErrorCode = 2
+	ErrorCodeInvalidArgument    ErrorCode = 3
+	ErrorCodeUnimplemented      ErrorCode = 4
+	ErrorCodeUnauthenticated    ErrorCode = 5
+	ErrorCodePermissionDenied   ErrorCode = 6
+	ErrorCodeAlreadyExists      ErrorCode = 7
+	ErrorCodeDeadlineExceeded   ErrorCode = 8
+	ErrorCodeResourceExhausted  ErrorCode = 9
+	ErrorCodeCanceled           ErrorCode = 10
+	ErrorCodeUnavailable        ErrorCode = 11
+	ErrorCodeFailedPrecondition ErrorCode = 12
+	ErrorCodeAborted            ErrorCode = 13
+	ErrorCodeOutOfRange         ErrorCode = 14
+	ErrorCodeDataLoss           ErrorCode = 15
 )
 
 func (c ErrorCode) String() string {
@@ -40,6 +47,20 @@ func (c ErrorCode) String() string {
 		return "STORMRPC_CODE_ALREADY_EXISTS"
 	case ErrorCodeDeadlineExceeded:
 		return "STORMRPC_CODE_DEADLINE_EXCEEDED"
+	case ErrorCodeResourceExhausted:
+		return "STORMRPC_CODE_RESOURCE_EXHAUSTED"
+	case ErrorCodeCanceled:
+		return "STORMRPC_CODE_CANCELED"
+	case ErrorCodeUnavailable:
+		return "STORMRPC_CODE_UNAVAILABLE"
+	case ErrorCodeFailedPrecondition:
+		return "STORMRPC_CODE_FAILED_PRECONDITION"
+	case ErrorCodeAborted:
+		return "STORMRPC_CODE_ABORTED"
+	case ErrorCodeOutOfRange:
+		return "STORMRPC_CODE_OUT_OF_RANGE"
+	case ErrorCodeDataLoss:
+		return "STORMRPC_CODE_DATA_LOSS"
 	default:
 		return "STORMRPC_CODE_UNKNOWN"
 	}
@@ -49,6 +70,13 @@ func (c ErrorCode) String() string {
 type Error struct {
 	Message string
 	Code    ErrorCode
+	// Details carry additional, structured information about the error.
+	Details []ErrorDetail
+	// Metadata carries additional key/value information about the error.
+	Metadata map[string]string
+
+	// cause is the underlying error, if any.
+	cause error
 }
 
 // Error allows for the Error type to conform to the built-in error interface.
@@ -56,6 +84,11 @@ func (e Error) Error() string {
 	return fmt.Sprintf("%s: %s", e.Code.String(), e.Message)
 }
 
+// Unwrap returns the underlying error the Error was created from, if any.
+func (e Error) Unwrap() error {
+	return e.cause
+}
+
 // Errorf constructs a new RPC Error.
 func Errorf(code ErrorCode, format string, args ...any) *Error {
 	return &Error{
@@ -64,6 +97,36 @@ func Errorf(code ErrorCode, format string, args ...any) *Error {
 	}
 }
 
+// WithDetails returns a copy of the Error with the given details appended.
+func (e *Error) WithDetails(details ...ErrorDetail) *Error {
+	c := e.clone()
+	c.Details = append(c.Details, details...)
+	return c
+}
+
+// WithMetadata returns a copy of the Error with the given key/value pair added to its metadata.
+func (e *Error) WithMetadata(key, value string) *Error {
+	c := e.clone()
+	c.Metadata[key] = value
+	return c
+}
+
+func (e *Error) clone() *Error {
+	c := &Error{
+		Message:  e.Message,
+		Code:     e.Code,
+		cause:    e.cause,
+		Details:  make([]ErrorDetail, len(e.Details)),
+		Metadata: make(map[string]string, len(e.Metadata)),
+	}
+	copy(c.Details, e.Details)
+	for k, v := range e.Metadata {
+		c.Metadata[k] = v
+	}
+
+	return c
+}
+
 // CodeFromErr retrieves the ErrorCode from a given error.
 // If the error is not of type Error, ErrorCodeUnknown is returned.
 func CodeFromErr(err error) ErrorCode {
@@ -84,6 +147,26 @@ func MessageFromErr(err error) string {
 	return "unknown error"
 }
 
+// ErrorDetails retrieves the details from a given error.
+// If the error is not of type Error, nil is returned.
+func ErrorDetails(err error) []ErrorDetail {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Details
+	}
+	return nil
+}
+
+// ErrorMetadata retrieves the metadata from a given error.
+// If the error is not of type Error, nil is returned.
+func ErrorMetadata(err error) map[string]string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Metadata
+	}
+	return nil
+}
+
 func codeFromString(s string) ErrorCode {
 	switch s {
 	case "STORMRPC_CODE_INTERNAL":
@@ -102,7 +185,30 @@ func codeFromString(s string) ErrorCode {
 		return ErrorCodeAlreadyExists
 	case "STORMRPC_CODE_DEADLINE_EXCEEDED":
 		return ErrorCodeDeadlineExceeded
+	case "STORMRPC_CODE_RESOURCE_EXHAUSTED":
+		return ErrorCodeResourceExhausted
+	case "STORMRPC_CODE_CANCELED":
+		return ErrorCodeCanceled
+	case "STORMRPC_CODE_UNAVAILABLE":
+		return ErrorCodeUnavailable
+	case "STORMRPC_CODE_FAILED_PRECONDITION":
+		return ErrorCodeFailedPrecondition
+	case "STORMRPC_CODE_ABORTED":
+		return ErrorCodeAborted
+	case "STORMRPC_CODE_OUT_OF_RANGE":
+		return ErrorCodeOutOfRange
+	case "STORMRPC_CODE_DATA_LOSS":
+		return ErrorCodeDataLoss
 	default:
 		return ErrorCodeUnknown
 	}
 }
+
+// wrapError constructs a new RPC Error from an underlying error.
+func wrapError(code ErrorCode, err error) *Error {
+	return &Error{
+		Code:    code,
+		Message: err.Error(),
+		cause:   err,
+	}
+}
@@ -3,6 +3,7 @@ package stormrpc
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -52,6 +53,41 @@ func TestErrorCode_String(t *testing.T) {
 			c:    ErrorCodeAlreadyExists,
 			want: "STORMRPC_CODE_ALREADY_EXISTS",
 		},
+		{
+			name: "resource exhausted",
+			c:    ErrorCodeResourceExhausted,
+			want: "STORMRPC_CODE_RESOURCE_EXHAUSTED",
+		},
+		{
+			name: "canceled",
+			c:    ErrorCodeCanceled,
+			want: "STORMRPC_CODE_CANCELED",
+		},
+		{
+			name: "unavailable",
+			c:    ErrorCodeUnavailable,
+			want: "STORMRPC_CODE_UNAVAILABLE",
+		},
+		{
+			name: "failed precondition",
+			c:    ErrorCodeFailedPrecondition,
+			want: "STORMRPC_CODE_FAILED_PRECONDITION",
+		},
+		{
+			name: "aborted",
+			c:    ErrorCodeAborted,
+			want: "STORMRPC_CODE_ABORTED",
+		},
+		{
+			name: "out of range",
+			c:    ErrorCodeOutOfRange,
+			want: "STORMRPC_CODE_OUT_OF_RANGE",
+		},
+		{
+			name: "data loss",
+			c:    ErrorCodeDataLoss,
+			want: "STORMRPC_CODE_DATA_LOSS",
+		},
 		{
 			name: "default",
 			c:    10000,
@@ -165,6 +201,32 @@ func TestMessageFromErr(t *testing.T) {
 	}
 }
 
+func TestError_WithDetails(t *testing.T) {
+	base := Errorf(ErrorCodeInvalidArgument, "bad request")
+	got := base.WithDetails(FieldViolation{Field: "name", Description: "required"}).WithMetadata("region", "us-east-1")
+
+	if len(base.Details) != 0 || len(base.Metadata) != 0 {
+		t.Fatalf("expected original error to be unchanged got = %+v", base)
+	}
+
+	wantDetails := []ErrorDetail{FieldViolation{Field: "name", Description: "required"}}
+	if !reflect.DeepEqual(ErrorDetails(got), wantDetails) {
+		t.Errorf("ErrorDetails() = %v, want %v", ErrorDetails(got), wantDetails)
+	}
+
+	wantMetadata := map[string]string{"region": "us-east-1"}
+	if !reflect.DeepEqual(ErrorMetadata(got), wantMetadata) {
+		t.Errorf("ErrorMetadata() = %v, want %v", ErrorMetadata(got), wantMetadata)
+	}
+
+	if got := ErrorDetails(fmt.Errorf("howdy")); got != nil {
+		t.Errorf("ErrorDetails() = %v, want nil", got)
+	}
+	if got := ErrorMetadata(fmt.Errorf("howdy")); got != nil {
+		t.Errorf("ErrorMetadata() = %v, want nil", got)
+	}
+}
+
 func Test_codeFromString(t *testing.T) {
 	type args struct {
 		s string
@@ -230,6 +292,55 @@ func Test_codeFromString(t *testing.T) {
 			},
 			want: ErrorCodeAlreadyExists,
 		},
+		{
+			name: "resource exhausted",
+			args: args{
+				s: "STORMRPC_CODE_RESOURCE_EXHAUSTED",
+			},
+			want: ErrorCodeResourceExhausted,
+		},
+		{
+			name: "canceled",
+			args: args{
+				s: "STORMRPC_CODE_CANCELED",
+			},
+			want: ErrorCodeCanceled,
+		},
+		{
+			name: "unavailable",
+			args: args{
+				s: "STORMRPC_CODE_UNAVAILABLE",
+			},
+			want: ErrorCodeUnavailable,
+		},
+		{
+			name: "failed precondition",
+			args: args{
+				s: "STORMRPC_CODE_FAILED_PRECONDITION",
+			},
+			want: ErrorCodeFailedPrecondition,
+		},
+		{
+			name: "aborted",
+			args: args{
+				s: "STORMRPC_CODE_ABORTED",
+			},
+			want: ErrorCodeAborted,
+		},
+		{
+			name: "out of range",
+			args: args{
+				s: "STORMRPC_CODE_OUT_OF_RANGE",
+			},
+			want: ErrorCodeOutOfRange,
+		},
+		{
+			name: "data loss",
+			args: args{
+				s: "STORMRPC_CODE_DATA_LOSS",
+			},
+			want: ErrorCodeDataLoss,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,74 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+)
+
+// EventHandlerFunc is the function signature for handling an event published with Client.Publish.
+// Events have no reply, errors returned are passed to the server's ErrorHandler instead.
+type EventHandlerFunc func(ctx context.Context, r Request) error
+
+// EventHandler adapts an EventHandlerFunc to a HandlerFunc so events pass through the server's middleware.
+func EventHandler(fn EventHandlerFunc) HandlerFunc {
+	return func(ctx context.Context, r Request) Response {
+		return Response{
+			Msg: &nats.Msg{Header: nats.Header{}},
+			Err: fn(ctx, r),
+		}
+	}
+}
+
+// HandleEvent registers a new EventHandlerFunc on the server. Like other endpoints, event endpoints
+// subscribe with a queue group so each event is handled by a single server instance,
+// unless configured with WithNoQueueGroup.
+func (s *Server) HandleEvent(subject string, fn EventHandlerFunc, opts ...EndpointOption) {
+	s.Handle(subject, EventHandler(fn), opts...)
+}
+
+// Publish publishes an event to the subject of the request without waiting for a reply.
+// The request passes through the ClientMiddleware the Client was created with.
+func (c *Client) Publish(ctx context.Context, r Request, opts ...CallOption) error {
+	return c.publishInvoke(ctx, r, opts...).Err
+}
+
+func (c *Client) publish(ctx context.Context, r Request, opts ...CallOption) Response {
+	options := callOptions{
+		headers: make(map[string]string),
+	}
+	for _, o := range opts {
+		err := o.before(&options)
+		if err != nil {
+			return NewErrorResponse("", err)
+		}
+	}
+
+	c.applyOptions(ctx, &r, &options)
+
+	if options.compress != nil {
+		var err error
+		if r, err = options.compress.compressRequest(r); err != nil {
+			return NewErrorResponse("", err)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return NewErrorResponse("", requestError(err, r.Subject()))
+	}
+
+	// Events may be handled by several servers, so their stored bodies are left to expire.
+	r, _, err := c.payloads.storeRequest(ctx, r)
+	if err != nil {
+		return NewErrorResponse("", err)
+	}
+
+	msg := *r.Msg
+	msg.Reply = ""
+
+	return Response{
+		Msg: &nats.Msg{Header: nats.Header{}},
+		Err: c.nc.PublishMsg(&msg),
+	}
+}
@@ -0,0 +1,116 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClient_Publish(t *testing.T) {
+	clientURL := startNatsServer(t)
+
+	errs := make(chan error, 1)
+	srv, err := NewServer(&ServerConfig{
+		NatsURL: clientURL,
+		Name:    "test",
+	}, WithErrorHandler(func(ctx context.Context, err error) {
+		errs <- err
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Use(func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, r Request) Response {
+			r.Header.Set("X-Server-Middleware", "true")
+			return next(ctx, r)
+		}
+	})
+
+	events := make(chan Request, 1)
+	srv.HandleEvent("created", func(ctx context.Context, r Request) error {
+		events <- r
+		return nil
+	})
+	srv.HandleEvent("failed", func(ctx context.Context, r Request) error {
+		return Errorf(ErrorCodeInternal, "failed to handle event")
+	})
+
+	go func() {
+		_ = srv.Run()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown(context.Background())
+	})
+
+	if !srv.ready(250 * time.Millisecond) {
+		t.Fatal("server not ready after 250 milliseconds")
+	}
+
+	client, err := NewClient(clientURL, WithClientMiddleware(func(next Invoker) Invoker {
+		return func(ctx context.Context, r Request, opts ...CallOption) Response {
+			r.Header.Set("X-Client-Middleware", "true")
+			return next(ctx, r, opts...)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	t.Run("event handled", func(t *testing.T) {
+		err := client.Publish(
+			context.Background(),
+			mustNewRequest(t, "created", map[string]string{"id": "1"}),
+			WithHeaders(map[string]string{"X-Option": "true"}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case r := <-events:
+			var body map[string]string
+			if err = r.Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body["id"] != "1" {
+				t.Fatalf("got = %v, want %v", body["id"], "1")
+			}
+			for _, h := range []string{"X-Server-Middleware", "X-Client-Middleware", "X-Option"} {
+				if r.Header.Get(h) != "true" {
+					t.Fatalf("%s got = %v, want %v", h, r.Header.Get(h), "true")
+				}
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for event")
+		}
+	})
+
+	t.Run("error goes to error handler", func(t *testing.T) {
+		if err := client.Publish(context.Background(), mustNewRequest(t, "failed", nil)); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case err := <-errs:
+			if CodeFromErr(err) != ErrorCodeInternal {
+				t.Fatalf("got = %v, want %v", CodeFromErr(err), ErrorCodeInternal)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for error")
+		}
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := client.Publish(ctx, mustNewRequest(t, "created", nil))
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("got = %v, want %v", err, context.Canceled)
+		}
+	})
+}
@@ -48,7 +48,11 @@ type EchoerServer interface {
 func RegisterEchoerServer(s *stormrpc.Server, srv EchoerServer) {
 	for _, handler := range echoerHandlers {
 		handler.SetService(srv)
-		s.Handle(handler.Route(), handler.HandlerFunc())
+		var opts []stormrpc.EndpointOption
+		if handler.Stream() {
+			opts = append(opts, stormrpc.WithStreamEndpoint())
+		}
+		s.Handle(handler.Route(), handler.HandlerFunc(), opts...)
 	}
 }
 
@@ -69,7 +73,7 @@ func (h *_Echoer_Echo_Handler) HandlerFunc() stormrpc.HandlerFunc {
 			return stormrpc.NewErrorResponse(r.Reply, err)
 		}
 
-		resp, err := stormrpc.NewResponse(r.Reply, out, stormrpc.WithEncodeProto())
+		resp, err := r.NewResponse(out, stormrpc.WithEncodeProto())
 		if err != nil {
 			return stormrpc.NewErrorResponse(r.Reply, err)
 		}
@@ -82,6 +86,10 @@ func (h *_Echoer_Echo_Handler) Route() string {
 	return h.route
 }
 
+func (h *_Echoer_Echo_Handler) Stream() bool {
+	return false
+}
+
 func (h *_Echoer_Echo_Handler) SetService(svc interface{}) {
 	h.svc = svc
 }
@@ -93,5 +101,6 @@ var echoerHandlers = []handler{
 type handler interface {
 	Route() string
 	HandlerFunc() stormrpc.HandlerFunc
+	Stream() bool
 	SetService(interface{})
 }
@@ -0,0 +1,103 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DoAll sends a request to every server instance handling the subject and collects their responses.
+// This is meant for endpoints registered with WithNoQueueGroup, endpoints in a queue group only reply once.
+//
+// Responses are collected until the count set with WithGatherCount is reached, no response was received
+// for the quiet period set with WithGatherQuietPeriod, or the context's deadline is reached. Either a
+// deadline or a quiet period must be set. Every response carries the id of the instance that sent it,
+// see Response.InstanceID. Errors returned by individual instances are set on their Response.
+//
+// Requests made with DoAll don't pass through the Client's middleware, retries, or circuit breakers.
+func (c *Client) DoAll(ctx context.Context, r Request, opts ...CallOption) ([]Response, error) {
+	options := callOptions{
+		headers: make(map[string]string),
+	}
+	for _, o := range opts {
+		err := o.before(&options)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.applyOptions(ctx, &r, &options)
+
+	if options.compress != nil {
+		var err error
+		if r, err = options.compress.compressRequest(r); err != nil {
+			return nil, err
+		}
+	}
+
+	r, name, err := c.payloads.storeRequest(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	if name != "" {
+		defer c.payloads.delete(name)
+	}
+
+	dl, ok := ctx.Deadline()
+	if ok {
+		setDeadlineHeader(r.Header, dl)
+	} else if options.gather.quietPeriod <= 0 {
+		return nil, Errorf(ErrorCodeInvalidArgument, "DoAll requires a deadline or a quiet period")
+	}
+
+	inbox := c.nc.NewRespInbox()
+	sub, err := c.nc.SubscribeSync(inbox)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = sub.Unsubscribe()
+	}()
+
+	msg := *r.Msg
+	msg.Reply = inbox
+	if err = c.nc.PublishMsg(&msg); err != nil {
+		return nil, err
+	}
+
+	var resps []Response
+	for options.gather.count <= 0 || len(resps) < options.gather.count {
+		m, err := c.nextResponse(ctx, sub, options.gather.quietPeriod)
+		if err != nil {
+			if errors.Is(err, nats.ErrNoResponders) {
+				return nil, requestError(err, r.Subject())
+			}
+			if ctx.Err() == nil || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				// The quiet period passed or the deadline was reached.
+				break
+			}
+			return resps, requestError(err, r.Subject())
+		}
+
+		resp := Response{Msg: m, payloads: c.payloads}
+		if rpcErr := parseErrorHeader(m.Header); rpcErr != nil {
+			resp.Err = rpcErr
+		}
+		resps = append(resps, resp)
+	}
+
+	return resps, nil
+}
+
+func (c *Client) nextResponse(ctx context.Context, sub *nats.Subscription, quietPeriod time.Duration) (*nats.Msg, error) {
+	if quietPeriod > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, quietPeriod)
+		defer cancel()
+	}
+
+	return sub.NextMsgWithContext(ctx)
+}
@@ -0,0 +1,120 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClient_DoAll(t *testing.T) {
+	clientURL := startNatsServer(t)
+
+	ids := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		srv, err := NewServer(&ServerConfig{
+			NatsURL: clientURL,
+			Name:    "test",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[srv.InstanceID()] = true
+
+		fail := i == 2
+		srv.Handle("gather", func(ctx context.Context, r Request) Response {
+			if fail {
+				return NewErrorResponse(r.Reply, Errorf(ErrorCodeInternal, "broken"))
+			}
+			resp, _ := NewResponse(r.Reply, map[string]string{"hello": "world"})
+			return resp
+		}, WithNoQueueGroup())
+
+		go func() {
+			_ = srv.Run()
+		}()
+		t.Cleanup(func() {
+			_ = srv.Shutdown(context.Background())
+		})
+
+		if !srv.ready(250 * time.Millisecond) {
+			t.Fatal("server not ready after 250 milliseconds")
+		}
+	}
+
+	client, err := NewClient(clientURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	checkResponses := func(t *testing.T, resps []Response) {
+		t.Helper()
+
+		if len(resps) != 3 {
+			t.Fatalf("got = %v, want %v", len(resps), 3)
+		}
+
+		seen := make(map[string]bool)
+		var failed int
+		for _, resp := range resps {
+			if !ids[resp.InstanceID()] {
+				t.Fatalf("unexpected instance id: %v", resp.InstanceID())
+			}
+			seen[resp.InstanceID()] = true
+			if resp.Err != nil {
+				failed++
+			}
+		}
+
+		if len(seen) != 3 {
+			t.Fatalf("got = %v, want %v", len(seen), 3)
+		}
+		if failed != 1 {
+			t.Fatalf("got = %v, want %v", failed, 1)
+		}
+	}
+
+	t.Run("count", func(t *testing.T) {
+		start := time.Now()
+		resps, err := client.DoAll(ctxWithTimeout(t, 5*time.Second), mustNewRequest(t, "gather", nil), WithGatherCount(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkResponses(t, resps)
+
+		if time.Since(start) > time.Second {
+			t.Fatalf("expected DoAll to return once all responses were received, took %v", time.Since(start))
+		}
+	})
+
+	t.Run("quiet period", func(t *testing.T) {
+		resps, err := client.DoAll(context.Background(), mustNewRequest(t, "gather", nil), WithGatherQuietPeriod(100*time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkResponses(t, resps)
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		resps, err := client.DoAll(ctxWithTimeout(t, 200*time.Millisecond), mustNewRequest(t, "gather", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkResponses(t, resps)
+	})
+
+	t.Run("no deadline or quiet period", func(t *testing.T) {
+		_, err := client.DoAll(context.Background(), mustNewRequest(t, "gather", nil), WithGatherCount(3))
+		if CodeFromErr(err) != ErrorCodeInvalidArgument {
+			t.Fatalf("got = %v, want %v", CodeFromErr(err), ErrorCodeInvalidArgument)
+		}
+	})
+
+	t.Run("no servers", func(t *testing.T) {
+		_, err := client.DoAll(ctxWithTimeout(t, time.Second), mustNewRequest(t, "nobody", nil))
+		if CodeFromErr(err) != ErrorCodeUnavailable {
+			t.Fatalf("got = %v, want %v", CodeFromErr(err), ErrorCodeUnavailable)
+		}
+	})
+}
@@ -2,29 +2,65 @@
 package stormrpc
 
 import (
+	"errors"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
 )
 
 const (
-	// errorHeader will be deprecated in a future update in favor of 'Nats-Service-Error' and 'Nats-Service-Error-Code'.
-	errorHeader    = "stormrpc-error"
+	// errorHeader is the legacy error header, replaced by 'Nats-Service-Error' and 'Nats-Service-Error-Code'.
+	// Servers only set it when configured with WithLegacyErrorHeader.
+	errorHeader = "stormrpc-error"
+	// deadlineHeader carries the absolute deadline of a request. Servers only rely on it for durable requests
+	// and requests from clients that don't send timeoutHeader, which set it in seconds since the unix epoch.
 	deadlineHeader = "stormrpc-deadline"
+	// timeoutHeader carries the time left until the deadline of a request in milliseconds when it was sent.
+	// Unlike deadlineHeader it's unaffected by clock skew between hosts.
+	timeoutHeader = "stormrpc-timeout"
+	// errorStatusHeader carries the JSON encoded Error, including its details and metadata.
+	errorStatusHeader = "stormrpc-error-status"
+	// streamEndHeader marks the final message sent by either side of a stream.
+	streamEndHeader = "stormrpc-stream-end"
+	// streamSeqHeader carries the sequence number of a stream message, used for ordering.
+	streamSeqHeader = "stormrpc-stream-seq"
+	// streamOpenHeader is set by clients opening a stream that sends messages to the server.
+	streamOpenHeader = "stormrpc-stream-open"
+	// streamInboxHeader carries the subject a server receives client stream messages on.
+	streamInboxHeader = "stormrpc-stream-inbox"
+	// streamCancelHeader signals the other side of a stream that it was cancelled.
+	streamCancelHeader = "stormrpc-stream-cancel"
+	// attemptHeader carries the attempt number of a request retried by a Client.
+	attemptHeader = "stormrpc-attempt"
+	// instanceIDHeader carries the id of the server instance that sent a reply.
+	instanceIDHeader = "stormrpc-instance-id"
+	// cancelIDHeader carries the id clients publish a cancel notice for when the request's context is
+	// canceled before the reply was received.
+	cancelIDHeader = "stormrpc-cancel-id"
+	// replyToHeader carries the inbox the reply to a durable request is sent to.
+	replyToHeader = "stormrpc-reply-to"
 )
 
+// setDeadlineHeader sets the timeout budget of a request with the given deadline, along with the absolute deadline.
 func setDeadlineHeader(header nats.Header, deadline time.Time) {
-	header.Set(deadlineHeader, strconv.FormatInt(deadline.Unix(), 10))
+	header.Set(timeoutHeader, strconv.FormatInt(time.Until(deadline).Milliseconds(), 10))
+	header.Set(deadlineHeader, deadline.UTC().Format(time.RFC3339Nano))
 }
 
+// parseDeadlineHeader returns the absolute deadline of a request, or the zero time if it has none.
 func parseDeadlineHeader(header nats.Header) time.Time {
 	dh := header.Get(deadlineHeader)
 	if dh == "" {
 		return time.Time{}
 	}
 
+	if dl, err := time.Parse(time.RFC3339Nano, dh); err == nil {
+		return dl
+	}
+
 	i, err := strconv.ParseInt(dh, 10, 64)
 	if err != nil {
 		return time.Time{}
@@ -33,17 +69,112 @@ func parseDeadlineHeader(header nats.Header) time.Time {
 	return time.Unix(i, 0)
 }
 
-func setErrorHeader(header nats.Header, err error) {
-	header.Set(errorHeader, err.Error())
+// requestDeadline returns the deadline of a request received at the given time, or the zero time if it has none.
+// The timeout budget is preferred over the absolute deadline, so time the request spent queued on the server
+// counts against it while the clocks of the client and server don't need to agree.
+func requestDeadline(header nats.Header, received time.Time) time.Time {
+	th := header.Get(timeoutHeader)
+	if th == "" {
+		return parseDeadlineHeader(header)
+	}
+
+	ms, err := strconv.ParseInt(th, 10, 64)
+	if err != nil {
+		return parseDeadlineHeader(header)
+	}
+
+	return received.Add(time.Duration(ms) * time.Millisecond)
+}
+
+func setAttemptHeader(header nats.Header, attempt int) {
+	header.Set(attemptHeader, strconv.Itoa(attempt))
+}
+
+func parseAttemptHeader(header nats.Header) int {
+	ah := header.Get(attemptHeader)
+	if ah == "" {
+		return 1
+	}
+
+	i, err := strconv.Atoi(ah)
+	if err != nil || i < 1 {
+		return 1
+	}
+
+	return i
+}
+
+func setStreamSeqHeader(header nats.Header, seq uint64) {
+	header.Set(streamSeqHeader, strconv.FormatUint(seq, 10))
+}
+
+func parseStreamSeqHeader(header nats.Header) (uint64, bool) {
+	sh := header.Get(streamSeqHeader)
+	if sh == "" {
+		return 0, false
+	}
+
+	seq, err := strconv.ParseUint(sh, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return seq, true
+}
+
+// setErrorHeader sets the headers describing err that aren't part of the micro error protocol.
+// The legacy error header is only set if legacy is true.
+func setErrorHeader(header nats.Header, err error, legacy bool) {
+	if legacy {
+		header.Set(errorHeader, err.Error())
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		status, encErr := encodeErrorStatus(e)
+		if encErr == nil {
+			header.Set(errorStatusHeader, status)
+		}
+	}
+}
+
+// serviceError returns the code and description an error is sent with in the micro error headers.
+// The code is the HTTP status of the error's ErrorCode, as micro tooling expects numeric codes.
+// The exact ErrorCode is sent in the error status header.
+func serviceError(err error) (code, description string) {
+	c := CodeFromErr(err)
+	code = strconv.Itoa(c.HTTPStatus())
+	description = MessageFromErr(err)
+	if description == "" {
+		description = c.String()
+	}
+
+	return code, description
 }
 
+// parseErrorHeader parses the error from the headers of a reply. Errors sent by stormRPC servers,
+// plain micro services and legacy stormRPC servers are supported.
 func parseErrorHeader(header nats.Header) *Error {
+	if sh := header.Get(errorStatusHeader); sh != "" {
+		e, err := decodeErrorStatus(sh)
+		if err == nil {
+			return e
+		}
+	}
+
+	if desc := header.Get(micro.ErrorHeader); desc != "" {
+		return &Error{
+			Code:    codeFromMicroCode(header.Get(micro.ErrorCodeHeader)),
+			Message: desc,
+		}
+	}
+
 	eh := header.Get(errorHeader)
 	if eh == "" {
 		return nil
 	}
 
-	sp := strings.Split(eh, ":")
+	sp := strings.SplitN(eh, ":", 2)
 
 	if len(sp) < 2 {
 		return &Error{
@@ -64,3 +195,18 @@ func parseErrorHeader(header nats.Header) *Error {
 		Message: msg,
 	}
 }
+
+// codeFromMicroCode converts the error code of a micro error to an ErrorCode. Besides the HTTP
+// status codes commonly used by micro services, the codes sent by older stormRPC servers are understood.
+func codeFromMicroCode(code string) ErrorCode {
+	if c := codeFromString(code); c != ErrorCodeUnknown {
+		return c
+	}
+
+	status, err := strconv.Atoi(code)
+	if err != nil {
+		return ErrorCodeUnknown
+	}
+
+	return CodeFromHTTPStatus(status)
+}
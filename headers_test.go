@@ -2,12 +2,14 @@
 package stormrpc
 
 import (
+	"errors"
 	"reflect"
 	"strconv"
 	"testing"
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
 )
 
 func Test_parseErrorHeader(t *testing.T) {
@@ -62,6 +64,88 @@ func Test_parseErrorHeader(t *testing.T) {
 				Message: "unknown error",
 			},
 		},
+		{
+			name: "message with colons",
+			args: args{
+				header: nats.Header{
+					errorHeader: []string{"STORMRPC_CODE_INTERNAL: get http://localhost:8080: connection refused"},
+				},
+			},
+			want: &Error{
+				Code:    ErrorCodeInternal,
+				Message: "get http://localhost:8080: connection refused",
+			},
+		},
+		{
+			name: "error status header",
+			args: args{
+				header: func() nats.Header {
+					h := nats.Header{}
+					setErrorHeader(h, Errorf(ErrorCodeInvalidArgument, "invalid: name").
+						WithDetails(FieldViolation{Field: "name", Description: "required"}).
+						WithMetadata("region", "us-east-1"), false)
+					return h
+				}(),
+			},
+			want: &Error{
+				Code:     ErrorCodeInvalidArgument,
+				Message:  "invalid: name",
+				Details:  []ErrorDetail{FieldViolation{Field: "name", Description: "required"}},
+				Metadata: map[string]string{"region": "us-east-1"},
+			},
+		},
+		{
+			name: "micro error",
+			args: args{
+				header: nats.Header{
+					micro.ErrorHeader:     []string{"thingy: not found"},
+					micro.ErrorCodeHeader: []string{"404"},
+				},
+			},
+			want: &Error{
+				Code:    ErrorCodeNotFound,
+				Message: "thingy: not found",
+			},
+		},
+		{
+			name: "micro error with stormrpc code",
+			args: args{
+				header: nats.Header{
+					micro.ErrorHeader:     []string{"try again"},
+					micro.ErrorCodeHeader: []string{"STORMRPC_CODE_RESOURCE_EXHAUSTED"},
+				},
+			},
+			want: &Error{
+				Code:    ErrorCodeResourceExhausted,
+				Message: "try again",
+			},
+		},
+		{
+			name: "micro error with unknown code",
+			args: args{
+				header: nats.Header{
+					micro.ErrorHeader:     []string{"teapot"},
+					micro.ErrorCodeHeader: []string{"418"},
+				},
+			},
+			want: &Error{
+				Code:    ErrorCodeUnknown,
+				Message: "teapot",
+			},
+		},
+		{
+			name: "malformed error status header",
+			args: args{
+				header: nats.Header{
+					errorStatusHeader: []string{"{"},
+					errorHeader:       []string{"STORMRPC_CODE_NOT_FOUND: new error"},
+				},
+			},
+			want: &Error{
+				Code:    ErrorCodeNotFound,
+				Message: "new error",
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -72,6 +156,45 @@ func Test_parseErrorHeader(t *testing.T) {
 	}
 }
 
+func Test_serviceError(t *testing.T) {
+	tests := []struct {
+		name            string
+		err             error
+		wantCode        string
+		wantDescription string
+	}{
+		{
+			name:            "stormrpc error",
+			err:             Errorf(ErrorCodeResourceExhausted, "slow down"),
+			wantCode:        "429",
+			wantDescription: "slow down",
+		},
+		{
+			name:            "no message",
+			err:             &Error{Code: ErrorCodeNotFound},
+			wantCode:        "404",
+			wantDescription: ErrorCodeNotFound.String(),
+		},
+		{
+			name:            "plain error",
+			err:             errors.New("boom"),
+			wantCode:        "500",
+			wantDescription: "unknown error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, description := serviceError(tt.err)
+			if code != tt.wantCode {
+				t.Errorf("serviceError() code = %v, want %v", code, tt.wantCode)
+			}
+			if description != tt.wantDescription {
+				t.Errorf("serviceError() description = %v, want %v", description, tt.wantDescription)
+			}
+		})
+	}
+}
+
 func Test_parseDeadlineHeader(t *testing.T) {
 	type args struct {
 		header nats.Header
@@ -98,6 +221,15 @@ func Test_parseDeadlineHeader(t *testing.T) {
 			},
 			want: time.Time{},
 		},
+		{
+			name: "header with rfc3339 time",
+			args: args{
+				header: nats.Header{
+					deadlineHeader: []string{"2024-05-01T10:00:00.25Z"},
+				},
+			},
+			want: time.Date(2024, 5, 1, 10, 0, 0, 250000000, time.UTC),
+		},
 		{
 			name: "header with unix time",
 			args: args{
@@ -116,3 +248,123 @@ func Test_parseDeadlineHeader(t *testing.T) {
 		})
 	}
 }
+
+func Test_parseStreamSeqHeader(t *testing.T) {
+	type args struct {
+		header nats.Header
+	}
+	tests := []struct {
+		name   string
+		args   args
+		want   uint64
+		wantOk bool
+	}{
+		{
+			name: "no header",
+			args: args{
+				header: nats.Header{},
+			},
+			want:   0,
+			wantOk: false,
+		},
+		{
+			name: "header non int",
+			args: args{
+				header: nats.Header{
+					streamSeqHeader: []string{"bob"},
+				},
+			},
+			want:   0,
+			wantOk: false,
+		},
+		{
+			name: "header with sequence",
+			args: args{
+				header: nats.Header{
+					streamSeqHeader: []string{"42"},
+				},
+			},
+			want:   42,
+			wantOk: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseStreamSeqHeader(tt.args.header)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("parseStreamSeqHeader() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_setDeadlineHeader(t *testing.T) {
+	header := nats.Header{}
+	deadline := time.Now().Add(300 * time.Millisecond)
+	setDeadlineHeader(header, deadline)
+
+	ms, err := strconv.ParseInt(header.Get(timeoutHeader), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms <= 0 || ms > 300 {
+		t.Fatalf("got = %v, want a budget of at most %v", ms, 300)
+	}
+
+	if got := parseDeadlineHeader(header); !got.Equal(deadline) {
+		t.Fatalf("got = %v, want %v", got, deadline)
+	}
+}
+
+func Test_requestDeadline(t *testing.T) {
+	received := time.Now()
+
+	tests := []struct {
+		name   string
+		header nats.Header
+		want   time.Time
+	}{
+		{
+			name:   "no header",
+			header: nats.Header{},
+			want:   time.Time{},
+		},
+		{
+			name: "timeout budget",
+			header: nats.Header{
+				timeoutHeader:  []string{"300"},
+				deadlineHeader: []string{received.Add(time.Hour).Format(time.RFC3339Nano)},
+			},
+			want: received.Add(300 * time.Millisecond),
+		},
+		{
+			name: "exhausted budget",
+			header: nats.Header{
+				timeoutHeader: []string{"0"},
+			},
+			want: received,
+		},
+		{
+			name: "invalid budget",
+			header: nats.Header{
+				timeoutHeader:  []string{"bob"},
+				deadlineHeader: []string{"1700000000"},
+			},
+			want: time.Unix(1700000000, 0),
+		},
+		{
+			name: "legacy deadline",
+			header: nats.Header{
+				deadlineHeader: []string{"1700000000"},
+			},
+			want: time.Unix(1700000000, 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requestDeadline(tt.header, received); !got.Equal(tt.want) {
+				t.Fatalf("got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
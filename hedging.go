@@ -0,0 +1,80 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// HedgingPolicy configures hedged requests. A hedged request sends additional copies of the request when
+// no response arrived within Delay, and returns the first successful response. The remaining copies are
+// cancelled. Hedging is only allowed for calls marked with WithIdempotent, since the server may process
+// every copy.
+type HedgingPolicy struct {
+	// MaxAttempts is the maximum number of copies sent, including the first one.
+	// Values lower than 2 disable hedging.
+	MaxAttempts int
+	// Delay is the time to wait for a response before sending the next copy.
+	// A copy failing with an error causes the next one to be sent immediately.
+	Delay time.Duration
+}
+
+func (p *HedgingPolicy) enabled() bool {
+	return p != nil && p.MaxAttempts > 1
+}
+
+func (p *HedgingPolicy) do(ctx context.Context, r Request, send func(context.Context, Request) Response) Response {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan Response, p.MaxAttempts)
+	sent, pending := 0, 0
+	sendCopy := func() {
+		req := cloneRequest(r)
+		sent++
+		pending++
+		go func() {
+			results <- send(ctx, req)
+		}()
+	}
+
+	sendCopy()
+
+	t := time.NewTimer(p.Delay)
+	defer t.Stop()
+
+	var resp Response
+	for pending > 0 {
+		select {
+		case resp = <-results:
+			pending--
+			if resp.Err == nil {
+				return resp
+			}
+			if sent < p.MaxAttempts && ctx.Err() == nil {
+				sendCopy()
+				t.Reset(p.Delay)
+			}
+		case <-t.C:
+			if sent < p.MaxAttempts {
+				sendCopy()
+				t.Reset(p.Delay)
+			}
+		}
+	}
+
+	return resp
+}
+
+// cloneRequest copies the request so copies can be sent concurrently.
+func cloneRequest(r Request) Request {
+	msg := *r.Msg
+	msg.Header = make(nats.Header, len(r.Header))
+	for k, v := range r.Header {
+		msg.Header[k] = append([]string(nil), v...)
+	}
+
+	return Request{Msg: &msg}
+}
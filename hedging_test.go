@@ -0,0 +1,94 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_DoHedging(t *testing.T) {
+	clientURL := startNatsServer(t)
+
+	srv, err := NewServer(&ServerConfig{
+		NatsURL: clientURL,
+		Name:    "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls atomic.Int64
+	srv.Handle("hedge", func(ctx context.Context, r Request) Response {
+		if calls.Add(1) == 1 {
+			time.Sleep(500 * time.Millisecond)
+		}
+		resp, _ := NewResponse(r.Reply, map[string]string{"hello": "world"})
+		return resp
+	}, WithEndpointConcurrency(Concurrency{MaxInFlight: 3}))
+	srv.Handle("unavailable", func(ctx context.Context, r Request) Response {
+		calls.Add(1)
+		return NewErrorResponse(r.Reply, Errorf(ErrorCodeUnavailable, "unavailable"))
+	}, WithEndpointConcurrency(Concurrency{MaxInFlight: 3}))
+
+	go func() {
+		_ = srv.Run()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown(context.Background())
+	})
+
+	if !srv.ready(250 * time.Millisecond) {
+		t.Fatal("server not ready after 250 milliseconds")
+	}
+
+	client, err := NewClient(clientURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	policy := HedgingPolicy{MaxAttempts: 3, Delay: 20 * time.Millisecond}
+
+	t.Run("first successful response wins", func(t *testing.T) {
+		calls.Store(0)
+		start := time.Now()
+		resp := client.Do(ctxWithTimeout(t, time.Second), mustNewRequest(t, "hedge", nil), WithIdempotent(), WithHedging(policy))
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+
+		if time.Since(start) > 250*time.Millisecond {
+			t.Fatalf("expected hedged request to complete before the slow one, took %v", time.Since(start))
+		}
+
+		if got := calls.Load(); got != 2 {
+			t.Fatalf("got = %v, want %v", got, 2)
+		}
+	})
+
+	t.Run("all copies fail", func(t *testing.T) {
+		calls.Store(0)
+		resp := client.Do(ctxWithTimeout(t, time.Second), mustNewRequest(t, "unavailable", nil), WithIdempotent(), WithHedging(policy))
+		if CodeFromErr(resp.Err) != ErrorCodeUnavailable {
+			t.Fatalf("got = %v, want %v", CodeFromErr(resp.Err), ErrorCodeUnavailable)
+		}
+
+		if got := calls.Load(); got != 3 {
+			t.Fatalf("got = %v, want %v", got, 3)
+		}
+	})
+
+	t.Run("not idempotent", func(t *testing.T) {
+		calls.Store(0)
+		resp := client.Do(ctxWithTimeout(t, time.Second), mustNewRequest(t, "hedge", nil), WithHedging(policy))
+		if CodeFromErr(resp.Err) != ErrorCodeInvalidArgument {
+			t.Fatalf("got = %v, want %v", CodeFromErr(resp.Err), ErrorCodeInvalidArgument)
+		}
+
+		if got := calls.Load(); got != 0 {
+			t.Fatalf("got = %v, want %v", got, 0)
+		}
+	})
+}
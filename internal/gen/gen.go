@@ -105,13 +105,8 @@ func genService(g *protogen.GeneratedFile, service *protogen.Service) {
 	g.P("}")
 	g.P()
 
-	var methodIndex int
 	for _, method := range service.Methods {
-		if !method.Desc.IsStreamingServer() && !method.Desc.IsStreamingClient() {
-			// Unary RPC method
-			genClientMethod(g, method)
-			methodIndex++
-		}
+		genClientMethod(g, method)
 	}
 
 	// Server interface.
@@ -140,7 +135,11 @@ func genService(g *protogen.GeneratedFile, service *protogen.Service) {
 	g.P("func Register", service.GoName, "Server(s *", stormrpcPackage.Ident("Server"), ", srv ", serverType, ") {")
 	g.P("for _, handler := range ", unexport(service.GoName), "Handlers {")
 	g.P("handler.SetService(srv)")
-	g.P("s.Handle(handler.Route(), handler.HandlerFunc())")
+	g.P("var opts []", stormrpcPackage.Ident("EndpointOption"))
+	g.P("if handler.Stream() {")
+	g.P("opts = append(opts, ", stormrpcPackage.Ident("WithStreamEndpoint"), "())")
+	g.P("}")
+	g.P("s.Handle(handler.Route(), handler.HandlerFunc(), opts...)")
 	g.P("}")
 	g.P("}")
 	g.P()
@@ -165,6 +164,7 @@ func genHandlerInterface(g *protogen.GeneratedFile) {
 	g.P("type handler interface {")
 	g.P("Route() string")
 	g.P("HandlerFunc() stormrpc.HandlerFunc")
+	g.P("Stream() bool")
 	g.P("SetService(interface{})")
 	g.P("}")
 }
@@ -178,7 +178,7 @@ func clientSignature(g *protogen.GeneratedFile, method *protogen.Method) string
 	if !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer() {
 		s += "*" + g.QualifiedGoIdent(method.Output.GoIdent)
 	} else {
-		s += method.Parent.GoName + "_" + method.GoName + "Client"
+		s += streamTypeName(method) + "Client"
 	}
 	s += ", error)"
 	return s
@@ -209,6 +209,89 @@ func genClientMethod(
 		g.P()
 		return
 	}
+
+	streamType := unexport(service.GoName) + method.GoName + "Client"
+	if !method.Desc.IsStreamingClient() {
+		g.P(`r, err := stormrpc.NewRequest("` + routeSignature(service, method) + `", in, stormrpc.WithEncodeProto())`)
+		g.P("if err != nil { return nil, err }")
+		g.P()
+		g.P("s, err := c.c.Stream(ctx, r, opts...)")
+	} else {
+		g.P(`s, err := c.c.OpenStream(ctx, "` + routeSignature(service, method) + `", opts...)`)
+	}
+	g.P("if err != nil { return nil, err }")
+	g.P()
+	g.P("return &", streamType, "{s}, nil")
+	g.P("}")
+	g.P()
+
+	genClientStream(g, method)
+}
+
+func genClientStream(g *protogen.GeneratedFile, method *protogen.Method) {
+	service := method.Parent
+	streamType := unexport(service.GoName) + method.GoName + "Client"
+	clientStreaming := method.Desc.IsStreamingClient()
+	serverStreaming := method.Desc.IsStreamingServer()
+
+	g.P("type ", streamTypeName(method), "Client interface {")
+	if clientStreaming {
+		g.P("Send(*", method.Input.GoIdent, ") error")
+	}
+	if serverStreaming {
+		g.P("Recv() (*", method.Output.GoIdent, ", error)")
+	} else {
+		g.P("CloseAndRecv() (*", method.Output.GoIdent, ", error)")
+	}
+	if clientStreaming && serverStreaming {
+		g.P("CloseSend() error")
+	}
+	g.P("Close() error")
+	g.P("}")
+	g.P()
+
+	g.P("type ", streamType, " struct {")
+	g.P("s *", stormrpcPackage.Ident("ClientStream"))
+	g.P("}")
+	g.P()
+
+	if clientStreaming {
+		g.P("func (x *", streamType, ") Send(m *", method.Input.GoIdent, ") error {")
+		g.P(`r, err := stormrpc.NewRequest("` + routeSignature(service, method) + `", m, stormrpc.WithEncodeProto())`)
+		g.P("if err != nil { return err }")
+		g.P()
+		g.P("return x.s.Send(r)")
+		g.P("}")
+		g.P()
+	}
+
+	if serverStreaming {
+		g.P("func (x *", streamType, ") Recv() (*", method.Output.GoIdent, ", error) {")
+		g.P("resp := x.s.Recv()")
+	} else {
+		g.P("func (x *", streamType, ") CloseAndRecv() (*", method.Output.GoIdent, ", error) {")
+		g.P("resp := x.s.CloseAndRecv()")
+	}
+	g.P("if resp.Err != nil { return nil, resp.Err }")
+	g.P()
+	g.P("var out ", method.Output.GoIdent)
+	g.P("if err := resp.Decode(&out); err != nil { return nil, err }")
+	g.P()
+	g.P("return &out, nil")
+	g.P("}")
+	g.P()
+
+	if clientStreaming && serverStreaming {
+		g.P("func (x *", streamType, ") CloseSend() error {")
+		g.P("return x.s.CloseSend()")
+		g.P("}")
+		g.P()
+	}
+
+	g.P("func (x *", streamType, ") Close() error {")
+	g.P("return x.s.Close()")
+	g.P("}")
+	g.P()
 }
 
 func serverSignature(g *protogen.GeneratedFile, method *protogen.Method) string {
@@ -222,7 +305,7 @@ func serverSignature(g *protogen.GeneratedFile, method *protogen.Method) string
 		reqArgs = append(reqArgs, "*"+g.QualifiedGoIdent(method.Input.GoIdent))
 	}
 	if method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer() {
-		reqArgs = append(reqArgs, method.Parent.GoName+"_"+method.GoName+"Server")
+		reqArgs = append(reqArgs, streamTypeName(method)+"Server")
 	}
 	return method.GoName + "(" + strings.Join(reqArgs, ", ") + ") " + ret
 }
@@ -234,12 +317,13 @@ func genServerHandler(
 	service := method.Parent
 	hname := unexport(fmt.Sprintf("_%s_%s_Handler", service.GoName, method.GoName))
 
+	g.P("type ", hname, " struct {")
+	g.P("route string")
+	g.P("svc interface{}")
+	g.P("}")
+	g.P()
+
 	if !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer() {
-		g.P("type ", hname, " struct {")
-		g.P("route string")
-		g.P("svc interface{}")
-		g.P("}")
-		g.P()
 		g.P("func (h *", hname, ") HandlerFunc() stormrpc.HandlerFunc {")
 		g.P("return func(ctx ", contextPackage.Ident("Context"), ", r stormrpc.Request) stormrpc.Response {")
 		g.P("var in ", method.Input.GoIdent)
@@ -249,32 +333,112 @@ func genServerHandler(
 		g.P("out, err := h.svc.(", service.GoName, "Server).", method.GoName, "(ctx, &in)")
 		g.P("if err != nil { return stormrpc.NewErrorResponse(r.Reply, err) }")
 		g.P()
-		g.P("resp, err := stormrpc.NewResponse(r.Reply, out, stormrpc.WithEncodeProto())")
+		g.P("resp, err := r.NewResponse(out, stormrpc.WithEncodeProto())")
 		g.P("if err != nil { return stormrpc.NewErrorResponse(r.Reply, err) }")
 		g.P()
 		g.P("return resp")
 		g.P("}")
 		g.P("}")
+	} else {
+		streamType := unexport(service.GoName) + method.GoName + "Server"
+		g.P("func (h *", hname, ") HandlerFunc() stormrpc.HandlerFunc {")
+		g.P("return stormrpc.StreamHandler(func(ctx ", contextPackage.Ident("Context"),
+			", r stormrpc.Request, s *stormrpc.ServerStream) error {")
+		if !method.Desc.IsStreamingClient() {
+			g.P("var in ", method.Input.GoIdent)
+			g.P(`if err := r.Decode(&in); err != nil { return `, fmtPackage.Ident("Errorf"), `("error decoding request") }`)
+			g.P()
+			g.P("return h.svc.(", service.GoName, "Server).", method.GoName, "(&in, &", streamType, "{s: s, r: r})")
+		} else {
+			g.P("return h.svc.(", service.GoName, "Server).", method.GoName, "(&", streamType, "{s: s, r: r})")
+		}
+		g.P("})")
+		g.P("}")
+	}
 
-		g.P("")
+	g.P("")
 
-		g.P("func (h *", hname, ") Route() string {")
-		g.P("return h.route")
-		g.P("}")
+	g.P("func (h *", hname, ") Route() string {")
+	g.P("return h.route")
+	g.P("}")
 
-		g.P("")
+	g.P("")
 
-		g.P("func (h *", hname, ") SetService(svc interface{}) {")
-		g.P("h.svc = svc")
-		g.P("}")
-		g.P()
+	g.P("func (h *", hname, ") Stream() bool {")
+	g.P("return ", strconv.FormatBool(method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer()))
+	g.P("}")
+
+	g.P("")
+
+	g.P("func (h *", hname, ") SetService(svc interface{}) {")
+	g.P("h.svc = svc")
+	g.P("}")
+	g.P()
 
-		return hname
+	if method.Desc.IsStreamingServer() || method.Desc.IsStreamingClient() {
+		genServerStream(g, method)
 	}
 
 	return hname
 }
 
+func genServerStream(g *protogen.GeneratedFile, method *protogen.Method) {
+	service := method.Parent
+	streamType := unexport(service.GoName) + method.GoName + "Server"
+	clientStreaming := method.Desc.IsStreamingClient()
+	serverStreaming := method.Desc.IsStreamingServer()
+
+	sendMethod := "Send"
+	if !serverStreaming {
+		sendMethod = "SendAndClose"
+	}
+
+	g.P("type ", streamTypeName(method), "Server interface {")
+	g.P(sendMethod, "(*", method.Output.GoIdent, ") error")
+	if clientStreaming {
+		g.P("Recv() (*", method.Input.GoIdent, ", error)")
+	}
+	g.P("Context() ", contextPackage.Ident("Context"))
+	g.P("}")
+	g.P()
+
+	g.P("type ", streamType, " struct {")
+	g.P("s *", stormrpcPackage.Ident("ServerStream"))
+	g.P("r ", stormrpcPackage.Ident("Request"))
+	g.P("}")
+	g.P()
+
+	g.P("func (x *", streamType, ") ", sendMethod, "(m *", method.Output.GoIdent, ") error {")
+	g.P("resp, err := x.r.NewResponse(m, stormrpc.WithEncodeProto())")
+	g.P("if err != nil { return err }")
+	g.P()
+	g.P("return x.s.Send(resp)")
+	g.P("}")
+	g.P()
+
+	if clientStreaming {
+		g.P("func (x *", streamType, ") Recv() (*", method.Input.GoIdent, ", error) {")
+		g.P("r, err := x.s.Recv()")
+		g.P("if err != nil { return nil, err }")
+		g.P()
+		g.P("var in ", method.Input.GoIdent)
+		g.P("if err = r.Decode(&in); err != nil { return nil, err }")
+		g.P()
+		g.P("return &in, nil")
+		g.P("}")
+		g.P()
+	}
+
+	g.P("func (x *", streamType, ") Context() ", contextPackage.Ident("Context"), " {")
+	g.P("return x.s.Context()")
+	g.P("}")
+	g.P()
+}
+
+func streamTypeName(method *protogen.Method) string {
+	return method.Parent.GoName + "_" + method.GoName
+}
+
 func routeSignature(service *protogen.Service, method *protogen.Method) string {
 	return fmt.Sprintf("rpc.%s.%s", service.GoName, method.GoName)
 }
@@ -37,6 +37,56 @@ func TestGenerateFiles(t *testing.T) {
 		}
 	})
 
+	t.Run("server_stream", func(t *testing.T) {
+		plugin := parseFilesIntoRequest(t, []string{"server_stream.proto"})
+
+		GenerateFiles(plugin)
+
+		resp := plugin.Response()
+
+		if len(resp.File) != 1 {
+			t.Fatal("expected only one file to be generated")
+		}
+
+		goldenFile, err := os.ReadFile("./testdata/server_stream.golden")
+		if err != nil {
+			t.Fatal("reading golden file: %w", err)
+		}
+
+		diff := cmp.Diff(
+			resp.File[0].GetContent(),
+			string(goldenFile),
+		)
+		if diff != "" {
+			t.Fatalf("diff: %s", diff)
+		}
+	})
+
+	t.Run("client_stream", func(t *testing.T) {
+		plugin := parseFilesIntoRequest(t, []string{"client_stream.proto"})
+
+		GenerateFiles(plugin)
+
+		resp := plugin.Response()
+
+		if len(resp.File) != 1 {
+			t.Fatal("expected only one file to be generated")
+		}
+
+		goldenFile, err := os.ReadFile("./testdata/client_stream.golden")
+		if err != nil {
+			t.Fatal("reading golden file: %w", err)
+		}
+
+		diff := cmp.Diff(
+			resp.File[0].GetContent(),
+			string(goldenFile),
+		)
+		if diff != "" {
+			t.Fatalf("diff: %s", diff)
+		}
+	})
+
 	t.Run("multiple_file", func(t *testing.T) {
 		plugin := parseFilesIntoRequest(t, []string{"multiple_file_1.proto", "multiple_file_2.proto"})
 
@@ -0,0 +1,194 @@
+// Package middleware provides some useful and commonly implemented middleware functions for StormRPC servers.
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/actatum/stormrpc"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	// IdempotencyKeyHeader is the header clients set to make a request idempotent.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotencyReplayedHeader is set on responses that are replayed for a duplicate request.
+	IdempotencyReplayedHeader = "Idempotency-Replayed"
+
+	idempotencySaveTimeout = 5 * time.Second
+)
+
+type idempotencyRecord struct {
+	Done   bool            `json:"done"`
+	Data   []byte          `json:"data,omitempty"`
+	Header nats.Header     `json:"header,omitempty"`
+	Error  json.RawMessage `json:"error,omitempty"`
+}
+
+// Idempotency makes requests carrying an Idempotency-Key header safe to retry. The first Response for a key
+// is stored in the key-value bucket and replayed for every duplicate request to the same subject.
+// A duplicate received while the first request is still being handled waits for its Response,
+// or fails with ErrorCodeAborted once its context is done.
+//
+// Responses are kept for the TTL the bucket was created with, see jetstream.KeyValueConfig.TTL.
+// Requests without an Idempotency-Key header are passed through.
+func Idempotency(kv jetstream.KeyValue) func(next stormrpc.HandlerFunc) stormrpc.HandlerFunc {
+	return func(next stormrpc.HandlerFunc) stormrpc.HandlerFunc {
+		return func(ctx context.Context, r stormrpc.Request) stormrpc.Response {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				return next(ctx, r)
+			}
+			key = idempotencyStoreKey(r.Subject(), key)
+
+			inProgress, _ := json.Marshal(idempotencyRecord{})
+			for {
+				rev, err := kv.Create(ctx, key, inProgress)
+				if err == nil {
+					resp := next(ctx, r)
+					saveIdempotentResponse(ctx, kv, key, rev, resp)
+					return resp
+				}
+				if !errors.Is(err, jetstream.ErrKeyExists) {
+					return stormrpc.NewErrorResponse(
+						r.Reply,
+						stormrpc.Errorf(stormrpc.ErrorCodeUnavailable, "failed to store idempotency key: %v", err),
+					)
+				}
+
+				resp, ok, err := waitForIdempotentResponse(ctx, kv, key, r.Reply)
+				if err != nil {
+					return stormrpc.NewErrorResponse(r.Reply, err)
+				}
+				if ok {
+					return resp
+				}
+				// The first request failed to store its response, try to handle the request again.
+			}
+		}
+	}
+}
+
+// idempotencyStoreKey scopes idempotency keys to the subject and hashes them,
+// since keys in the bucket are restricted to a limited set of characters.
+func idempotencyStoreKey(subject, key string) string {
+	sum := sha256.Sum256([]byte(subject + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+func saveIdempotentResponse(
+	ctx context.Context,
+	kv jetstream.KeyValue,
+	key string,
+	rev uint64,
+	resp stormrpc.Response,
+) {
+	// The response is saved even if the request's context is done, otherwise duplicates would wait
+	// for it until the key expires.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), idempotencySaveTimeout)
+	defer cancel()
+
+	rec := idempotencyRecord{Done: true}
+	if resp.Msg != nil {
+		rec.Data = resp.Data
+		rec.Header = resp.Header
+	}
+	var err error
+	if resp.Err != nil {
+		rec.Error, err = stormrpc.MarshalError(resp.Err)
+	}
+
+	var b []byte
+	if err == nil {
+		b, err = json.Marshal(rec)
+	}
+	if err == nil {
+		_, err = kv.Update(ctx, key, b, rev)
+	}
+	if err != nil {
+		_ = kv.Delete(ctx, key)
+	}
+}
+
+// waitForIdempotentResponse waits for the request holding the key to store its response.
+// It reports false if the key was removed instead.
+func waitForIdempotentResponse(
+	ctx context.Context,
+	kv jetstream.KeyValue,
+	key string,
+	reply string,
+) (stormrpc.Response, bool, error) {
+	w, err := kv.Watch(ctx, key)
+	if err != nil {
+		return stormrpc.Response{}, false, stormrpc.Errorf(
+			stormrpc.ErrorCodeUnavailable,
+			"failed to watch idempotency key: %v",
+			err,
+		)
+	}
+	defer func() {
+		_ = w.Stop()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return stormrpc.Response{}, false, stormrpc.Errorf(
+				stormrpc.ErrorCodeAborted,
+				"a request with the same idempotency key is still in progress",
+			)
+		case entry, ok := <-w.Updates():
+			if !ok {
+				return stormrpc.Response{}, false, nil
+			}
+			if entry == nil {
+				continue
+			}
+			if entry.Operation() != jetstream.KeyValuePut {
+				return stormrpc.Response{}, false, nil
+			}
+
+			var rec idempotencyRecord
+			if err = json.Unmarshal(entry.Value(), &rec); err != nil {
+				return stormrpc.Response{}, false, stormrpc.Errorf(
+					stormrpc.ErrorCodeInternal,
+					"failed to decode idempotent response: %v",
+					err,
+				)
+			}
+			if rec.Done {
+				return rec.response(reply), true, nil
+			}
+		}
+	}
+}
+
+func (rec idempotencyRecord) response(reply string) stormrpc.Response {
+	header := nats.Header{}
+	for k, v := range rec.Header {
+		header[k] = v
+	}
+	header.Set(IdempotencyReplayedHeader, "true")
+
+	resp := stormrpc.Response{
+		Msg: &nats.Msg{
+			Subject: reply,
+			Header:  header,
+			Data:    rec.Data,
+		},
+	}
+	if rec.Error != nil {
+		e, err := stormrpc.UnmarshalError(rec.Error)
+		if err != nil {
+			e = stormrpc.Errorf(stormrpc.ErrorCodeInternal, "failed to decode stored error: %v", err)
+		}
+		resp.Err = e
+	}
+
+	return resp
+}
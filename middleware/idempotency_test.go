@@ -0,0 +1,245 @@
+// Package middleware provides some useful and commonly implemented middleware functions for StormRPC servers.
+package middleware
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/actatum/stormrpc"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestIdempotency(t *testing.T) {
+	kv := newKeyValue(t)
+
+	newRequest := func(t *testing.T, subject, key string) stormrpc.Request {
+		t.Helper()
+
+		req, err := stormrpc.NewRequest(subject, map[string]string{"hi": "there"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		return req
+	}
+
+	counter := func(calls *atomic.Int64) stormrpc.HandlerFunc {
+		return func(ctx context.Context, r stormrpc.Request) stormrpc.Response {
+			n := calls.Add(1)
+			resp, err := stormrpc.NewResponse(r.Reply, map[string]int64{"call": n})
+			if err != nil {
+				return stormrpc.NewErrorResponse(r.Reply, err)
+			}
+			resp.Header.Set("X-Custom", "value")
+			return resp
+		}
+	}
+
+	decodeCall := func(t *testing.T, resp stormrpc.Response) int64 {
+		t.Helper()
+
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+		var body map[string]int64
+		if err := resp.Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return body["call"]
+	}
+
+	t.Run("no idempotency key", func(t *testing.T) {
+		var calls atomic.Int64
+		h := Idempotency(kv)(counter(&calls))
+
+		for i := 0; i < 2; i++ {
+			h(context.Background(), newRequest(t, "no.key", ""))
+		}
+		if got := calls.Load(); got != 2 {
+			t.Fatalf("got = %v, want %v", got, 2)
+		}
+	})
+
+	t.Run("duplicate request replayed", func(t *testing.T) {
+		var calls atomic.Int64
+		h := Idempotency(kv)(counter(&calls))
+
+		first := h(context.Background(), newRequest(t, "duplicate", "key-1"))
+		if first.Header.Get(IdempotencyReplayedHeader) != "" {
+			t.Fatalf("got = %v, want %v", first.Header.Get(IdempotencyReplayedHeader), "")
+		}
+
+		second := h(context.Background(), newRequest(t, "duplicate", "key-1"))
+		if got := decodeCall(t, second); got != 1 {
+			t.Fatalf("got = %v, want %v", got, 1)
+		}
+		if second.Header.Get(IdempotencyReplayedHeader) != "true" {
+			t.Fatalf("got = %v, want %v", second.Header.Get(IdempotencyReplayedHeader), "true")
+		}
+		if second.Header.Get("X-Custom") != "value" {
+			t.Fatalf("got = %v, want %v", second.Header.Get("X-Custom"), "value")
+		}
+		if got := calls.Load(); got != 1 {
+			t.Fatalf("got = %v, want %v", got, 1)
+		}
+	})
+
+	t.Run("keys are scoped to the subject", func(t *testing.T) {
+		var calls atomic.Int64
+		h := Idempotency(kv)(counter(&calls))
+
+		h(context.Background(), newRequest(t, "scoped.a", "key-1"))
+		resp := h(context.Background(), newRequest(t, "scoped.b", "key-1"))
+		if got := decodeCall(t, resp); got != 2 {
+			t.Fatalf("got = %v, want %v", got, 2)
+		}
+	})
+
+	t.Run("error replayed", func(t *testing.T) {
+		var calls atomic.Int64
+		h := Idempotency(kv)(func(ctx context.Context, r stormrpc.Request) stormrpc.Response {
+			calls.Add(1)
+			return stormrpc.NewErrorResponse(r.Reply, &stormrpc.Error{
+				Code:     stormrpc.ErrorCodeNotFound,
+				Message:  "order not found",
+				Metadata: map[string]string{"id": "1"},
+				Details:  []stormrpc.ErrorDetail{stormrpc.FieldViolation{Field: "id", Description: "unknown order"}},
+			})
+		})
+
+		h(context.Background(), newRequest(t, "error", "key-1"))
+		resp := h(context.Background(), newRequest(t, "error", "key-1"))
+		if stormrpc.CodeFromErr(resp.Err) != stormrpc.ErrorCodeNotFound {
+			t.Fatalf("got = %v, want %v", stormrpc.CodeFromErr(resp.Err), stormrpc.ErrorCodeNotFound)
+		}
+		if stormrpc.MessageFromErr(resp.Err) != "order not found" {
+			t.Fatalf("got = %v, want %v", stormrpc.MessageFromErr(resp.Err), "order not found")
+		}
+		if stormrpc.ErrorMetadata(resp.Err)["id"] != "1" {
+			t.Fatalf("got = %v, want %v", stormrpc.ErrorMetadata(resp.Err)["id"], "1")
+		}
+		wantDetails := []stormrpc.ErrorDetail{stormrpc.FieldViolation{Field: "id", Description: "unknown order"}}
+		if !reflect.DeepEqual(stormrpc.ErrorDetails(resp.Err), wantDetails) {
+			t.Fatalf("got = %v, want %v", stormrpc.ErrorDetails(resp.Err), wantDetails)
+		}
+		if got := calls.Load(); got != 1 {
+			t.Fatalf("got = %v, want %v", got, 1)
+		}
+	})
+
+	t.Run("duplicate waits for request in progress", func(t *testing.T) {
+		var calls atomic.Int64
+		started := make(chan struct{})
+		release := make(chan struct{})
+		h := Idempotency(kv)(func(ctx context.Context, r stormrpc.Request) stormrpc.Response {
+			if calls.Load() == 0 {
+				close(started)
+				<-release
+			}
+			return counter(&calls)(ctx, r)
+		})
+
+		first := newRequest(t, "in.progress", "key-1")
+		second := newRequest(t, "in.progress", "key-1")
+
+		go h(context.Background(), first)
+		<-started
+
+		result := make(chan stormrpc.Response, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			result <- h(ctx, second)
+		}()
+
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+
+		resp := <-result
+		if got := decodeCall(t, resp); got != 1 {
+			t.Fatalf("got = %v, want %v", got, 1)
+		}
+		if resp.Header.Get(IdempotencyReplayedHeader) != "true" {
+			t.Fatalf("got = %v, want %v", resp.Header.Get(IdempotencyReplayedHeader), "true")
+		}
+	})
+
+	t.Run("duplicate gives up on request in progress", func(t *testing.T) {
+		release := make(chan struct{})
+		t.Cleanup(func() {
+			close(release)
+		})
+		started := make(chan struct{})
+		h := Idempotency(kv)(func(ctx context.Context, r stormrpc.Request) stormrpc.Response {
+			close(started)
+			<-release
+			return stormrpc.NewErrorResponse(r.Reply, ctx.Err())
+		})
+
+		first := newRequest(t, "stuck", "key-1")
+		second := newRequest(t, "stuck", "key-1")
+
+		go h(context.Background(), first)
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		resp := h(ctx, second)
+		if stormrpc.CodeFromErr(resp.Err) != stormrpc.ErrorCodeAborted {
+			t.Fatalf("got = %v, want %v", stormrpc.CodeFromErr(resp.Err), stormrpc.ErrorCodeAborted)
+		}
+	})
+}
+
+func newKeyValue(t *testing.T) jetstream.KeyValue {
+	t.Helper()
+
+	ns, err := server.NewServer(&server.Options{
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ns.Start()
+
+	t.Cleanup(func() {
+		ns.Shutdown()
+		ns.WaitForShutdown()
+	})
+
+	if !ns.ReadyForConnections(1 * time.Second) {
+		t.Fatal("timeout waiting for nats server")
+	}
+
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kv, err := js.CreateKeyValue(context.Background(), jetstream.KeyValueConfig{
+		Bucket: "idempotency",
+		TTL:    time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return kv
+}
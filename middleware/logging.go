@@ -54,3 +54,48 @@ func Logger(l *slog.Logger) func(next stormrpc.HandlerFunc) stormrpc.HandlerFunc
 		}
 	}
 }
+
+// ClientLogger logs information about requests made with a stormrpc.Client such as the subject,
+// request id, trace information, and request duration.
+// This middleware should be applied after ClientRequestID, and ClientTracing.
+func ClientLogger(l *slog.Logger) stormrpc.ClientMiddleware {
+	return func(next stormrpc.Invoker) stormrpc.Invoker {
+		return func(ctx context.Context, r stormrpc.Request, opts ...stormrpc.CallOption) stormrpc.Response {
+			start := time.Now()
+
+			resp := next(ctx, r, opts...)
+
+			span := trace.SpanFromContext(ctx)
+			attrs := make([]slog.Attr, 0)
+
+			level := slog.LevelInfo
+			msg := "Success"
+			if resp.Err != nil {
+				msg = "Client Error"
+				level = slog.LevelError
+				code := stormrpc.CodeFromErr(resp.Err)
+				attrs = append(attrs, slog.Group(
+					"error",
+					slog.String("message", resp.Err.Error()),
+					slog.String("code", code.String()),
+				))
+			}
+
+			attrs = append(attrs, slog.Group("request",
+				slog.String("subject", r.Subject()),
+				slog.String("id", r.Header.Get(RequestIDHeader)),
+				slog.String("trace_id", span.SpanContext().TraceID().String()),
+				slog.String("duration", time.Since(start).String()),
+			))
+
+			l.LogAttrs(
+				ctx,
+				level,
+				msg,
+				attrs...,
+			)
+
+			return resp
+		}
+	}
+}
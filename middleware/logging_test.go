@@ -83,3 +83,61 @@ func TestLogger(t *testing.T) {
 		}
 	})
 }
+
+func TestClientLogger(t *testing.T) {
+	t.Run("success response", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		logger := slog.New(slog.NewJSONHandler(buf, nil))
+
+		req, _ := stormrpc.NewRequest("test", map[string]string{"hi": "there"})
+		invoker := stormrpc.Invoker(func(ctx context.Context, r stormrpc.Request, opts ...stormrpc.CallOption) stormrpc.Response {
+			resp, err := stormrpc.NewResponse(r.Reply, map[string]string{"hello": "world"})
+			if err != nil {
+				return stormrpc.NewErrorResponse(r.Reply, err)
+			}
+			return resp
+		})
+
+		h := ClientRequestID(ClientLogger(logger)(invoker))
+		_ = h(context.Background(), req)
+
+		var out logOutput
+		if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+
+		if out.Level != slog.LevelInfo {
+			t.Errorf("got level = %v, want %v", out.Level, slog.LevelInfo)
+		} else if out.Msg != "Success" {
+			t.Errorf("got msg = %v, want %v", out.Msg, "Success")
+		} else if out.Request.ID != req.Header.Get(RequestIDHeader) {
+			t.Errorf("got id = %v, want %v", out.Request.ID, req.Header.Get(RequestIDHeader))
+		}
+	})
+
+	t.Run("error response", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		logger := slog.New(slog.NewJSONHandler(buf, nil))
+
+		req, _ := stormrpc.NewRequest("test", map[string]string{"hi": "there"})
+		invoker := stormrpc.Invoker(func(ctx context.Context, r stormrpc.Request, opts ...stormrpc.CallOption) stormrpc.Response {
+			return stormrpc.NewErrorResponse(r.Reply, stormrpc.Errorf(stormrpc.ErrorCodeUnavailable, "no servers"))
+		})
+
+		h := ClientRequestID(ClientLogger(logger)(invoker))
+		_ = h(context.Background(), req)
+
+		var out logOutput
+		if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+
+		if out.Level != slog.LevelError {
+			t.Errorf("got level = %v, want %v", out.Level, slog.LevelError)
+		} else if out.Msg != "Client Error" {
+			t.Errorf("got msg = %v, want %v", out.Msg, "Client Error")
+		} else if out.Error.Code != stormrpc.ErrorCodeUnavailable.String() {
+			t.Errorf("got error code = %v, want %v", out.Error.Code, stormrpc.ErrorCodeUnavailable.String())
+		}
+	})
+}
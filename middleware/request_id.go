@@ -34,3 +34,24 @@ func RequestID(next stormrpc.HandlerFunc) stormrpc.HandlerFunc {
 		return resp
 	}
 }
+
+// ClientRequestID sets the request id header on requests made with a stormrpc.Client. The id is taken from
+// the context if one is present, so ids are propagated from incoming to outgoing requests, otherwise a uuid
+// is generated. Requests that already have the header set are left as is.
+func ClientRequestID(next stormrpc.Invoker) stormrpc.Invoker {
+	return func(ctx context.Context, r stormrpc.Request, opts ...stormrpc.CallOption) stormrpc.Response {
+		if r.Header == nil {
+			r.Header = nats.Header{}
+		}
+
+		if r.Header.Get(RequestIDHeader) == "" {
+			id := RequestIDFromContext(ctx)
+			if id == "" {
+				id = uuid.NewString()
+			}
+			r.Header.Set(RequestIDHeader, id)
+		}
+
+		return next(ctx, r, opts...)
+	}
+}
@@ -55,3 +55,41 @@ func TestRequestID(t *testing.T) {
 		}
 	})
 }
+
+func TestClientRequestID(t *testing.T) {
+	t.Run("id from context", func(t *testing.T) {
+		req, _ := stormrpc.NewRequest("test", map[string]string{"hi": "bye"})
+		ctx := NewContextWithRequestID(context.Background(), "testing")
+		invoker := stormrpc.Invoker(func(ctx context.Context, r stormrpc.Request, opts ...stormrpc.CallOption) stormrpc.Response {
+			if got := r.Header.Get(RequestIDHeader); got != "testing" {
+				t.Fatalf("got = %v, want %v", got, "testing")
+			}
+			return stormrpc.Response{}
+		})
+		ClientRequestID(invoker)(ctx, req)
+	})
+
+	t.Run("id generated", func(t *testing.T) {
+		req, _ := stormrpc.NewRequest("test", map[string]string{"hi": "bye"})
+		invoker := stormrpc.Invoker(func(ctx context.Context, r stormrpc.Request, opts ...stormrpc.CallOption) stormrpc.Response {
+			if _, err := uuid.Parse(r.Header.Get(RequestIDHeader)); err != nil {
+				t.Fatal("expected request id to be a uuid")
+			}
+			return stormrpc.Response{}
+		})
+		ClientRequestID(invoker)(context.Background(), req)
+	})
+
+	t.Run("header present", func(t *testing.T) {
+		req, _ := stormrpc.NewRequest("test", map[string]string{"hi": "bye"})
+		req.Header.Set(RequestIDHeader, "existing")
+		ctx := NewContextWithRequestID(context.Background(), "testing")
+		invoker := stormrpc.Invoker(func(ctx context.Context, r stormrpc.Request, opts ...stormrpc.CallOption) stormrpc.Response {
+			if got := r.Header.Get(RequestIDHeader); got != "existing" {
+				t.Fatalf("got = %v, want %v", got, "existing")
+			}
+			return stormrpc.Response{}
+		})
+		ClientRequestID(invoker)(ctx, req)
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/actatum/stormrpc"
 	"github.com/nats-io/nats.go"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
 )
@@ -41,3 +42,35 @@ func Tracing(tracer trace.Tracer) func(next stormrpc.HandlerFunc) stormrpc.Handl
 		}
 	}
 }
+
+// ClientTracing starts a client span for every request made with a stormrpc.Client and injects it into
+// the request headers, so the server's Tracing middleware continues the trace.
+func ClientTracing(tracer trace.Tracer) stormrpc.ClientMiddleware {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{}),
+	)
+	return func(next stormrpc.Invoker) stormrpc.Invoker {
+		return func(ctx context.Context, r stormrpc.Request, opts ...stormrpc.CallOption) stormrpc.Response {
+			ctx, clientSpan := tracer.Start(
+				ctx,
+				r.Subject(),
+				trace.WithSpanKind(trace.SpanKindClient),
+			)
+			defer clientSpan.End()
+
+			if r.Header == nil {
+				r.Header = nats.Header{}
+			}
+			otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(r.Header))
+
+			resp := next(ctx, r, opts...)
+			if resp.Err != nil {
+				clientSpan.RecordError(resp.Err)
+				clientSpan.SetStatus(codes.Error, resp.Err.Error())
+			}
+
+			return resp
+		}
+	}
+}
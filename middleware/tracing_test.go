@@ -76,3 +76,46 @@ func TestTracing(t *testing.T) {
 		}
 	})
 }
+
+func TestClientTracing(t *testing.T) {
+	tp := tracesdk.NewTracerProvider()
+	tr := tp.Tracer("")
+
+	t.Run("header is on request", func(t *testing.T) {
+		req, _ := stormrpc.NewRequest("test", map[string]string{"howdy": "partner"})
+		invoker := stormrpc.Invoker(func(ctx context.Context, r stormrpc.Request, opts ...stormrpc.CallOption) stormrpc.Response {
+			span := trace.SpanFromContext(ctx)
+			want := fmt.Sprintf("%s-%s-%s-%s",
+				"00",
+				span.SpanContext().TraceID(),
+				span.SpanContext().SpanID(),
+				span.SpanContext().TraceFlags().String(),
+			)
+
+			got := r.Header.Get("Traceparent")
+			if got != want {
+				t.Fatalf("got = %v, want %v", got, want)
+			}
+
+			return stormrpc.Response{}
+		})
+		ClientTracing(tr)(invoker)(context.Background(), req)
+	})
+
+	t.Run("continues server span", func(t *testing.T) {
+		req, _ := stormrpc.NewRequest("test", map[string]string{"howdy": "partner"})
+		var clientTraceID trace.TraceID
+		handler := stormrpc.HandlerFunc(func(ctx context.Context, r stormrpc.Request) stormrpc.Response {
+			span := trace.SpanFromContext(ctx)
+			if span.SpanContext().TraceID() != clientTraceID {
+				t.Fatalf("got = %v, want %v", span.SpanContext().TraceID(), clientTraceID)
+			}
+			return stormrpc.NewErrorResponse("test", fmt.Errorf("hi"))
+		})
+		invoker := stormrpc.Invoker(func(ctx context.Context, r stormrpc.Request, opts ...stormrpc.CallOption) stormrpc.Response {
+			clientTraceID = trace.SpanFromContext(ctx).SpanContext().TraceID()
+			return Tracing(tr)(handler)(context.Background(), r)
+		})
+		ClientTracing(tr)(invoker)(context.Background(), req)
+	})
+}
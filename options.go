@@ -1,7 +1,12 @@
 // Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
 package stormrpc
 
-import "github.com/nats-io/nats.go"
+import (
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
 
 // Option represents functional options used to configure stormRPC clients and servers.
 type Option interface {
@@ -15,7 +20,12 @@ type ClientOption interface {
 }
 
 type clientOptions struct {
-	nc *nats.Conn
+	nc             *nats.Conn
+	mw             []ClientMiddleware
+	retry          *RetryPolicy
+	circuitBreaker *CircuitBreaker
+	largePayloads  *LargePayloads
+	propagate      []string
 }
 
 type natsConnOption struct {
@@ -35,6 +45,123 @@ func WithNatsConn(nc *nats.Conn) Option {
 	return &natsConnOption{nc: nc}
 }
 
+type largePayloadsOption LargePayloads
+
+func (o largePayloadsOption) applyClient(c *clientOptions) {
+	lp := LargePayloads(o)
+	c.largePayloads = &lp
+}
+
+func (o largePayloadsOption) applyServer(c *ServerConfig) {
+	lp := LargePayloads(o)
+	c.largePayloads = &lp
+}
+
+// WithLargePayloads is an Option that stores request and response bodies above the threshold
+// in a JetStream object store bucket instead of sending them with the message.
+// Bodies stored by others are fetched by Decode regardless of whether it's set.
+func WithLargePayloads(lp LargePayloads) Option {
+	return largePayloadsOption(lp)
+}
+
+type clientMiddlewareOption []ClientMiddleware
+
+func (m clientMiddlewareOption) applyClient(c *clientOptions) {
+	c.mw = append(c.mw, m...)
+}
+
+// WithClientMiddleware is a ClientOption that wraps every request made with Client.Do in the given middleware.
+// The first middleware is the outermost one.
+func WithClientMiddleware(mw ...ClientMiddleware) ClientOption {
+	return clientMiddlewareOption(mw)
+}
+
+type propagateHeadersOption []string
+
+func (o propagateHeadersOption) applyClient(c *clientOptions) {
+	c.propagate = append(c.propagate, o...)
+}
+
+// WithPropagatedHeaders is a ClientOption that forwards the given headers of the request being handled
+// to requests made by the Client with the handler's context, see HeadersFromContext.
+// Headers already set on the outgoing request aren't overwritten.
+func WithPropagatedHeaders(keys ...string) ClientOption {
+	return propagateHeadersOption(keys)
+}
+
+type circuitBreakerOption CircuitBreaker
+
+func (o circuitBreakerOption) applyClient(c *clientOptions) {
+	cb := CircuitBreaker(o)
+	c.circuitBreaker = &cb
+}
+
+// WithCircuitBreaker is a ClientOption that guards every subject called by the Client with a circuit breaker
+// configured by cb. The state of a subject's circuit is available through Client.CircuitState.
+func WithCircuitBreaker(cb CircuitBreaker) ClientOption {
+	return circuitBreakerOption(cb)
+}
+
+// RetryOption is an option configuring the RetryPolicy of a Client.
+// As a ClientOption it sets the default policy of the Client, as a CallOption it sets the policy of a single request.
+type RetryOption interface {
+	ClientOption
+	CallOption
+}
+
+type retryOption RetryPolicy
+
+func (o retryOption) applyClient(c *clientOptions) {
+	p := RetryPolicy(o)
+	c.retry = &p
+}
+
+func (o retryOption) before(c *callOptions) error {
+	p := RetryPolicy(o)
+	c.retry = &p
+	return nil
+}
+
+func (retryOption) after(*callOptions) {}
+
+// WithRetry is a RetryOption that retries failed requests according to p.
+// Passing the zero RetryPolicy disables retries.
+func WithRetry(p RetryPolicy) RetryOption {
+	return retryOption(p)
+}
+
+// CompressionOption is an option configuring the Compression of message bodies.
+// As a ServerOption it compresses the responses to callers accepting its encoding, as a CallOption it compresses
+// the request and asks the server to compress the response.
+type CompressionOption interface {
+	ServerOption
+	CallOption
+}
+
+type compressionOption Compression
+
+func (o compressionOption) applyServer(c *ServerConfig) {
+	comp := Compression(o)
+	c.compression = &comp
+}
+
+func (o compressionOption) before(c *callOptions) error {
+	comp := Compression(o)
+	if err := comp.validate(); err != nil {
+		return err
+	}
+	c.compress = &comp
+	return nil
+}
+
+func (compressionOption) after(*callOptions) {}
+
+// WithCompression is a CompressionOption that compresses bodies at least as large as the Compression's
+// threshold. Clients and servers decompress bodies regardless of whether they were configured with it.
+func WithCompression(c Compression) CompressionOption {
+	return compressionOption(c)
+}
+
 // ServerOption represents functional options for configuring a stormRPC Server.
 type ServerOption interface {
 	applyServer(*ServerConfig)
@@ -51,6 +178,167 @@ func WithErrorHandler(fn ErrorHandler) ServerOption {
 	return errorHandlerOption(fn)
 }
 
+type concurrencyOption Concurrency
+
+func (c concurrencyOption) applyServer(opts *ServerConfig) {
+	opts.concurrency = Concurrency(c)
+}
+
+// WithConcurrency is a ServerOption that configures concurrent request processing.
+// Each endpoint gets its own pool of workers configured by c. Stream endpoints aren't limited
+// by it, since open streams would occupy the workers for as long as they're open.
+func WithConcurrency(c Concurrency) ServerOption {
+	return concurrencyOption(c)
+}
+
+type enforceDeadlinesOption struct{}
+
+func (enforceDeadlinesOption) applyServer(opts *ServerConfig) {
+	opts.enforceDeadlines = true
+}
+
+// WithEnforcedDeadlines is a ServerOption that replies with ErrorCodeDeadlineExceeded as soon as a request's
+// deadline passes, instead of waiting for handlers ignoring their context to return. The Response of such
+// handlers is discarded once they return, they are reported to the ErrorHandler and counted by
+// Server.AbandonedHandlers. Stream endpoints aren't affected.
+func WithEnforcedDeadlines() ServerOption {
+	return enforceDeadlinesOption{}
+}
+
+type legacyErrorHeaderOption struct{}
+
+func (legacyErrorHeaderOption) applyServer(opts *ServerConfig) {
+	opts.legacyErrorHeader = true
+}
+
+// WithLegacyErrorHeader is a ServerOption that additionally sends errors in the legacy 'stormrpc-error'
+// header, for compatibility with clients built with older versions of stormRPC.
+func WithLegacyErrorHeader() ServerOption {
+	return legacyErrorHeaderOption{}
+}
+
+// EndpointOption represents functional options for configuring a single endpoint registered with Server.Handle.
+type EndpointOption interface {
+	applyEndpoint(*endpointOptions)
+}
+
+type endpointOptions struct {
+	timeout      time.Duration
+	queueGroup   string
+	noQueueGroup bool
+	concurrency  *Concurrency
+	metadata     map[string]string
+	mw           []Middleware
+	stream       bool
+	workQueue    *WorkQueue
+}
+
+type endpointTimeoutOption time.Duration
+
+func (t endpointTimeoutOption) applyEndpoint(opts *endpointOptions) {
+	opts.timeout = time.Duration(t)
+}
+
+// WithEndpointTimeout is an EndpointOption that sets the timeout used for requests
+// that don't carry a deadline from the client. Streams registered with Server.HandleStream
+// have no timeout unless one is set with this option.
+func WithEndpointTimeout(d time.Duration) EndpointOption {
+	return endpointTimeoutOption(d)
+}
+
+type queueGroupOption string
+
+func (q queueGroupOption) applyEndpoint(opts *endpointOptions) {
+	opts.queueGroup = string(q)
+	opts.noQueueGroup = false
+}
+
+// WithQueueGroup is an EndpointOption that sets the name of the queue group the endpoint subscribes with.
+func WithQueueGroup(name string) EndpointOption {
+	return queueGroupOption(name)
+}
+
+type noQueueGroupOption struct{}
+
+func (noQueueGroupOption) applyEndpoint(opts *endpointOptions) {
+	opts.queueGroup = ""
+	opts.noQueueGroup = true
+}
+
+// WithNoQueueGroup is an EndpointOption that subscribes the endpoint without a queue group,
+// so every server instance receives every request.
+func WithNoQueueGroup() EndpointOption {
+	return noQueueGroupOption{}
+}
+
+type endpointConcurrencyOption Concurrency
+
+func (c endpointConcurrencyOption) applyEndpoint(opts *endpointOptions) {
+	cc := Concurrency(c)
+	opts.concurrency = &cc
+}
+
+// WithEndpointConcurrency is an EndpointOption that configures concurrent request processing
+// for the endpoint, overriding the server's WithConcurrency option. On stream endpoints
+// each open stream occupies one of the workers until it ends.
+func WithEndpointConcurrency(c Concurrency) EndpointOption {
+	return endpointConcurrencyOption(c)
+}
+
+type endpointMetadataOption map[string]string
+
+func (m endpointMetadataOption) applyEndpoint(opts *endpointOptions) {
+	if opts.metadata == nil {
+		opts.metadata = make(map[string]string, len(m))
+	}
+	for k, v := range m {
+		opts.metadata[k] = v
+	}
+}
+
+// WithEndpointMetadata is an EndpointOption that annotates the endpoint with metadata.
+// The metadata is available through the service's $SRV.INFO discovery subject.
+func WithEndpointMetadata(md map[string]string) EndpointOption {
+	return endpointMetadataOption(md)
+}
+
+type endpointMiddlewareOption []Middleware
+
+func (m endpointMiddlewareOption) applyEndpoint(opts *endpointOptions) {
+	opts.mw = append(opts.mw, m...)
+}
+
+// WithEndpointMiddleware is an EndpointOption that applies the given middleware to the endpoint only.
+// Endpoint middleware runs inside of the middleware registered with Server.Use.
+func WithEndpointMiddleware(mw ...Middleware) EndpointOption {
+	return endpointMiddlewareOption(mw)
+}
+
+type workQueueOption WorkQueue
+
+func (o workQueueOption) applyEndpoint(opts *endpointOptions) {
+	wq := WorkQueue(o)
+	opts.workQueue = &wq
+}
+
+// WithWorkQueue is an EndpointOption that makes the endpoint consume its requests from a JetStream
+// work queue stream, see WorkQueue. Requests to the endpoint must be sent with WithDurable.
+func WithWorkQueue(wq WorkQueue) EndpointOption {
+	return workQueueOption(wq)
+}
+
+type streamEndpointOption struct{}
+
+func (streamEndpointOption) applyEndpoint(opts *endpointOptions) {
+	opts.stream = true
+}
+
+// WithStreamEndpoint is an EndpointOption that marks the endpoint as streaming, see HandleStream.
+// It's used to register a HandlerFunc created with StreamHandler, as generated code does.
+func WithStreamEndpoint() EndpointOption {
+	return streamEndpointOption{}
+}
+
 // CallOption configures an RPC to perform actions before it starts or after
 // the RPC has completed.
 type CallOption interface {
@@ -64,7 +352,23 @@ type CallOption interface {
 
 // callOptions contains all configuration for an RPC.
 type callOptions struct {
-	headers map[string]string
+	headers    map[string]string
+	accept     string
+	compress   *Compression
+	retry      *RetryPolicy
+	hedging    *HedgingPolicy
+	idempotent bool
+	durable    bool
+	gather     gatherOptions
+
+	// responseHeader is the header of the response, set once the RPC has completed.
+	responseHeader nats.Header
+}
+
+// gatherOptions configure when Client.DoAll stops collecting responses.
+type gatherOptions struct {
+	count       int
+	quietPeriod time.Duration
 }
 
 // HeaderCallOption is used to configure which headers to append to the outgoing RPC.
@@ -83,3 +387,113 @@ func (o *HeaderCallOption) after(_ *callOptions) {}
 func WithHeaders(h map[string]string) CallOption {
 	return &HeaderCallOption{Headers: h}
 }
+
+type acceptOption string
+
+func (o acceptOption) before(c *callOptions) error {
+	c.accept = string(o)
+	return nil
+}
+
+func (acceptOption) after(*callOptions) {}
+
+// WithAccept returns a CallOption that sets the request's Accept header to the given content types,
+// in order of preference. Handlers creating their response with Request.NewResponse encode it
+// with the first content type they have a Codec registered for.
+func WithAccept(contentTypes ...string) CallOption {
+	return acceptOption(strings.Join(contentTypes, ", "))
+}
+
+type responseHeaderOption nats.Header
+
+func (responseHeaderOption) before(*callOptions) error {
+	return nil
+}
+
+func (o responseHeaderOption) after(c *callOptions) {
+	for k, v := range c.responseHeader {
+		o[k] = append([]string(nil), v...)
+	}
+}
+
+// WithResponseHeader returns a CallOption that copies the headers of the response into h once
+// Client.Do has completed, including the ones handlers set with SetResponseHeader.
+func WithResponseHeader(h nats.Header) CallOption {
+	return responseHeaderOption(h)
+}
+
+type idempotentOption struct{}
+
+func (idempotentOption) before(c *callOptions) error {
+	c.idempotent = true
+	return nil
+}
+
+func (idempotentOption) after(*callOptions) {}
+
+// WithIdempotent returns a CallOption marking the call as idempotent, meaning it is safe for the
+// server to process it more than once.
+func WithIdempotent() CallOption {
+	return idempotentOption{}
+}
+
+type hedgingOption HedgingPolicy
+
+func (o hedgingOption) before(c *callOptions) error {
+	p := HedgingPolicy(o)
+	c.hedging = &p
+	return nil
+}
+
+func (hedgingOption) after(*callOptions) {}
+
+// WithHedging returns a CallOption that hedges the request according to p.
+// The call must also be marked with WithIdempotent, otherwise it fails with ErrorCodeInvalidArgument.
+func WithHedging(p HedgingPolicy) CallOption {
+	return hedgingOption(p)
+}
+
+type gatherCountOption int
+
+func (o gatherCountOption) before(c *callOptions) error {
+	c.gather.count = int(o)
+	return nil
+}
+
+func (gatherCountOption) after(*callOptions) {}
+
+// WithGatherCount returns a CallOption that makes Client.DoAll return once n responses were received.
+func WithGatherCount(n int) CallOption {
+	return gatherCountOption(n)
+}
+
+type gatherQuietPeriodOption time.Duration
+
+func (o gatherQuietPeriodOption) before(c *callOptions) error {
+	c.gather.quietPeriod = time.Duration(o)
+	return nil
+}
+
+func (gatherQuietPeriodOption) after(*callOptions) {}
+
+// WithGatherQuietPeriod returns a CallOption that makes Client.DoAll return once no response was received
+// for d, counting from when the request was sent or the last response was received.
+func WithGatherQuietPeriod(d time.Duration) CallOption {
+	return gatherQuietPeriodOption(d)
+}
+
+type durableOption struct{}
+
+func (durableOption) before(c *callOptions) error {
+	c.durable = true
+	return nil
+}
+
+func (durableOption) after(*callOptions) {}
+
+// WithDurable returns a CallOption that sends the request through JetStream to an endpoint
+// configured with WithWorkQueue. The request is stored until a server handles it,
+// while the call waits for the reply until its context is done.
+func WithDurable() CallOption {
+	return durableOption{}
+}
@@ -4,6 +4,7 @@ package stormrpc
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestWithHeaders(t *testing.T) {
@@ -40,3 +41,62 @@ func TestWithHeaders(t *testing.T) {
 		})
 	}
 }
+
+func TestEndpointOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []EndpointOption
+		want endpointOptions
+	}{
+		{
+			name: "no options",
+			opts: nil,
+			want: endpointOptions{},
+		},
+		{
+			name: "timeout and queue group",
+			opts: []EndpointOption{
+				WithEndpointTimeout(time.Second),
+				WithQueueGroup("workers"),
+			},
+			want: endpointOptions{
+				timeout:    time.Second,
+				queueGroup: "workers",
+			},
+		},
+		{
+			name: "no queue group overrides queue group",
+			opts: []EndpointOption{
+				WithQueueGroup("workers"),
+				WithNoQueueGroup(),
+			},
+			want: endpointOptions{
+				noQueueGroup: true,
+			},
+		},
+		{
+			name: "concurrency and metadata",
+			opts: []EndpointOption{
+				WithEndpointConcurrency(Concurrency{MaxInFlight: 4}),
+				WithEndpointMetadata(map[string]string{"a": "1"}),
+				WithEndpointMetadata(map[string]string{"b": "2"}),
+			},
+			want: endpointOptions{
+				concurrency: &Concurrency{MaxInFlight: 4},
+				metadata:    map[string]string{"a": "1", "b": "2"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got endpointOptions
+			for _, o := range tt.opts {
+				o.applyEndpoint(&got)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applyEndpoint() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	payloadHeader = "stormrpc-payload"
+
+	defaultPayloadBucket = "stormrpc-payloads"
+	defaultPayloadTTL    = 10 * time.Minute
+	// payloadHeaderRoom is the part of the connection's max payload left for headers
+	// when no threshold is configured.
+	payloadHeaderRoom = 8 << 10
+	payloadTimeout    = 30 * time.Second
+)
+
+// LargePayloads configures storing bodies too large for a single NATS message in a JetStream object store bucket,
+// see WithLargePayloads. The message carries a reference to the object instead, which Decode fetches.
+type LargePayloads struct {
+	// Bucket is the name of the object store bucket bodies are stored in. It's created if it doesn't exist.
+	// It defaults to stormrpc-payloads.
+	Bucket string
+	// TTL is how long stored bodies are kept, so the ones never fetched don't accumulate. It defaults to 10m.
+	TTL time.Duration
+	// Threshold is the size in bytes above which bodies are stored. It defaults to the
+	// connection's max payload, less 8KiB for headers.
+	Threshold int
+}
+
+func (lp *LargePayloads) setDefaults() {
+	if lp.Bucket == "" {
+		lp.Bucket = defaultPayloadBucket
+	}
+	if lp.TTL <= 0 {
+		lp.TTL = defaultPayloadTTL
+	}
+}
+
+// payloadStore stores and fetches bodies referenced by the payload header. Bodies are only stored if it's
+// configured with LargePayloads, but referenced bodies can always be fetched.
+type payloadStore struct {
+	nc  *nats.Conn
+	cfg *LargePayloads
+
+	mu  sync.Mutex
+	obs jetstream.ObjectStore
+}
+
+func newPayloadStore(nc *nats.Conn, cfg *LargePayloads) *payloadStore {
+	if cfg != nil {
+		cfg.setDefaults()
+	}
+
+	return &payloadStore{
+		nc:  nc,
+		cfg: cfg,
+	}
+}
+
+func (p *payloadStore) threshold() int {
+	if p.cfg.Threshold > 0 {
+		return p.cfg.Threshold
+	}
+
+	return int(p.nc.MaxPayload()) - payloadHeaderRoom
+}
+
+// bucket returns the configured bucket, creating it on first use.
+func (p *payloadStore) bucket(ctx context.Context) (jetstream.ObjectStore, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.obs != nil {
+		return p.obs, nil
+	}
+
+	js, err := jetstream.New(p.nc)
+	if err != nil {
+		return nil, err
+	}
+
+	obs, err := js.CreateOrUpdateObjectStore(ctx, jetstream.ObjectStoreConfig{
+		Bucket: p.cfg.Bucket,
+		TTL:    p.cfg.TTL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.obs = obs
+
+	return obs, nil
+}
+
+// store moves the body of msg to the object store if it's larger than the threshold.
+// It returns the name of the stored object, or an empty string if the body wasn't stored.
+func (p *payloadStore) store(ctx context.Context, msg *nats.Msg) (string, error) {
+	if p == nil || p.cfg == nil || len(msg.Data) <= p.threshold() {
+		return "", nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), payloadTimeout)
+	defer cancel()
+
+	obs, err := p.bucket(ctx)
+	if err != nil {
+		return "", Errorf(ErrorCodeUnavailable, "failed to store body: %v", err)
+	}
+
+	name := uuid.NewString()
+	if _, err = obs.PutBytes(ctx, name, msg.Data); err != nil {
+		return "", Errorf(ErrorCodeUnavailable, "failed to store body: %v", err)
+	}
+
+	msg.Data = nil
+	msg.Header.Set(payloadHeader, p.cfg.Bucket+"/"+name)
+
+	return name, nil
+}
+
+// storeRequest returns a copy of the request with its body moved to the object store
+// if it's larger than the threshold, see store.
+func (p *payloadStore) storeRequest(ctx context.Context, r Request) (Request, string, error) {
+	if p == nil || p.cfg == nil || len(r.Data) <= p.threshold() {
+		return r, "", nil
+	}
+
+	r = cloneRequest(r)
+	name, err := p.store(ctx, r.Msg)
+
+	return r, name, err
+}
+
+// delete removes an object stored by store. Objects that can't be removed expire after the bucket's TTL.
+func (p *payloadStore) delete(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), payloadTimeout)
+	defer cancel()
+
+	obs, err := p.bucket(ctx)
+	if err != nil {
+		return
+	}
+	_ = obs.Delete(ctx, name)
+}
+
+// load replaces the body of msg with the object referenced by its payload header, removing
+// the object afterwards if remove is true.
+func (p *payloadStore) load(msg *nats.Msg, remove bool) error {
+	ref := msg.Header.Get(payloadHeader)
+	if ref == "" {
+		return nil
+	}
+	if p == nil {
+		return Errorf(ErrorCodeFailedPrecondition, "body is stored in object %s but no connection to fetch it", ref)
+	}
+
+	bucket, name, ok := strings.Cut(ref, "/")
+	if !ok {
+		return Errorf(ErrorCodeInvalidArgument, "invalid body reference: %s", ref)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), payloadTimeout)
+	defer cancel()
+
+	js, err := jetstream.New(p.nc)
+	if err != nil {
+		return err
+	}
+
+	obs, err := js.ObjectStore(ctx, bucket)
+	if err != nil {
+		return payloadError(err, ref)
+	}
+
+	data, err := obs.GetBytes(ctx, name)
+	if err != nil {
+		return payloadError(err, ref)
+	}
+
+	msg.Data = data
+	msg.Header.Del(payloadHeader)
+
+	if remove {
+		_ = obs.Delete(ctx, name)
+	}
+
+	return nil
+}
+
+func payloadError(err error, ref string) error {
+	if errors.Is(err, jetstream.ErrBucketNotFound) || errors.Is(err, jetstream.ErrObjectNotFound) {
+		return Errorf(ErrorCodeNotFound, "stored body %s expired or was removed", ref)
+	}
+
+	return Errorf(ErrorCodeUnavailable, "failed to fetch stored body %s: %v", ref, err)
+}
@@ -0,0 +1,138 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestClient_DoLargePayloads(t *testing.T) {
+	clientURL := startJetStreamServer(t)
+
+	lp := LargePayloads{Bucket: "payloads", Threshold: 1024}
+
+	srv, err := NewServer(&ServerConfig{
+		NatsURL: clientURL,
+		Name:    "test",
+	}, WithLargePayloads(lp))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refs := make(chan string, 1)
+	srv.Handle("echo", func(ctx context.Context, r Request) Response {
+		refs <- r.Header.Get(payloadHeader)
+
+		var body map[string]string
+		if err := r.Decode(&body); err != nil {
+			return NewErrorResponse(r.Reply, err)
+		}
+		resp, err := NewResponse(r.Reply, body)
+		if err != nil {
+			return NewErrorResponse(r.Reply, err)
+		}
+		return resp
+	})
+	go func() {
+		_ = srv.Run()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown(context.Background())
+	})
+
+	if !srv.ready(250 * time.Millisecond) {
+		t.Fatal("server not ready after 250 milliseconds")
+	}
+
+	client, err := NewClient(clientURL, WithLargePayloads(lp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	obs := func(t *testing.T) jetstream.ObjectStore {
+		t.Helper()
+
+		js, err := jetstream.New(client.nc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		obs, err := js.ObjectStore(context.Background(), "payloads")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return obs
+	}
+
+	t.Run("large body", func(t *testing.T) {
+		body := map[string]string{"hello": strings.Repeat("world", 500)}
+		req := mustNewRequest(t, "echo", body)
+
+		resp := client.Do(ctxWithTimeout(t, 5*time.Second), req)
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+
+		if ref := <-refs; !strings.HasPrefix(ref, "payloads/") {
+			t.Fatalf("got = %v, want %v", ref, "payloads/...")
+		}
+		if req.Header.Get(payloadHeader) != "" {
+			t.Fatal("request was modified")
+		}
+		if resp.Header.Get(payloadHeader) == "" || len(resp.Data) != 0 {
+			t.Fatalf("got = %v, want response body stored", resp.Header.Get(payloadHeader))
+		}
+
+		var got map[string]string
+		if err := resp.Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got["hello"] != body["hello"] {
+			t.Fatalf("got = %v, want %v", got["hello"], body["hello"])
+		}
+
+		// Both the request and the response were removed once fetched.
+		objects, err := obs(t).List(context.Background())
+		if err != nil && !errors.Is(err, jetstream.ErrNoObjectsFound) {
+			t.Fatal(err)
+		}
+		if len(objects) != 0 {
+			t.Fatalf("got = %v, want %v", len(objects), 0)
+		}
+	})
+
+	t.Run("small body", func(t *testing.T) {
+		resp := client.Do(ctxWithTimeout(t, 5*time.Second), mustNewRequest(t, "echo", map[string]string{"hello": "world"}))
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+
+		if ref := <-refs; ref != "" {
+			t.Fatalf("got = %v, want %v", ref, "")
+		}
+		if ref := resp.Header.Get(payloadHeader); ref != "" {
+			t.Fatalf("got = %v, want %v", ref, "")
+		}
+	})
+
+	t.Run("removed body", func(t *testing.T) {
+		resp := Response{
+			Msg: &nats.Msg{
+				Header: nats.Header{payloadHeader: []string{"payloads/missing"}},
+			},
+			payloads: client.payloads,
+		}
+
+		var got map[string]string
+		err := resp.Decode(&got)
+		if CodeFromErr(err) != ErrorCodeNotFound {
+			t.Fatalf("got = %v, want %v", CodeFromErr(err), ErrorCodeNotFound)
+		}
+	})
+}
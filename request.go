@@ -2,56 +2,36 @@
 package stormrpc
 
 import (
-	"encoding/json"
 	"fmt"
+	"slices"
 
 	"github.com/nats-io/nats.go"
-	"github.com/vmihailenco/msgpack/v5"
-	"google.golang.org/protobuf/proto"
 )
 
 // Request is stormRPC's wrapper around a nats.Msg and is used by both clients and servers.
 type Request struct {
 	*nats.Msg
+
+	payloads *payloadStore
 }
 
 // NewRequest constructs a new request with the given parameters. It also handles encoding the request body.
 func NewRequest(subject string, body any, opts ...RequestOption) (Request, error) {
 	options := requestOptions{
-		encodeProto:   false,
-		encodeMsgpack: false,
+		codec: JSONCodec{},
 	}
 
 	for _, o := range opts {
 		o.apply(&options)
 	}
 
-	var data []byte
-	var err error
-	var contentType string
-
-	switch {
-	case options.encodeProto:
-		switch m := body.(type) {
-		case proto.Message:
-			data, err = proto.Marshal(m)
-			contentType = "application/protobuf"
-		default:
-			return Request{}, fmt.Errorf("failed to encode proto message: invalid type: %T", m)
-		}
-	case options.encodeMsgpack:
-		data, err = msgpack.Marshal(body)
-		contentType = "application/msgpack"
-	default:
-		data, err = json.Marshal(body)
-		contentType = "application/json"
-	}
+	data, err := options.codec.Marshal(body)
 	if err != nil {
 		return Request{}, err
 	}
 
 	headers := nats.Header{}
-	headers.Set("Content-Type", contentType)
+	headers.Set(contentTypeHeader, options.codec.ContentType())
 	msg := &nats.Msg{
 		Data:    data,
 		Subject: subject,
@@ -64,8 +44,7 @@ func NewRequest(subject string, body any, opts ...RequestOption) (Request, error
 }
 
 type requestOptions struct {
-	encodeProto   bool
-	encodeMsgpack bool
+	codec Codec
 }
 
 // RequestOption represents functional options for configuring a request.
@@ -73,55 +52,73 @@ type RequestOption interface {
 	apply(options *requestOptions)
 }
 
-type encodeProtoOption bool
-
-func (p encodeProtoOption) apply(opts *requestOptions) {
-	opts.encodeProto = bool(p)
+type codecOption struct {
+	codec Codec
 }
 
-// WithEncodeProto is a RequestOption to encode the request body using the proto.Marshal method.
-func WithEncodeProto() RequestOption {
-	return encodeProtoOption(true)
+func (c codecOption) apply(opts *requestOptions) {
+	opts.codec = c.codec
 }
 
-type encodeMsgpackOption bool
+// WithCodec is a RequestOption to encode the request body using the given Codec.
+// Receivers must have a Codec registered for its content type, see RegisterCodec.
+func WithCodec(c Codec) RequestOption {
+	return codecOption{codec: c}
+}
 
-func (p encodeMsgpackOption) apply(opts *requestOptions) {
-	opts.encodeMsgpack = bool(p)
+// WithEncodeProto is a RequestOption to encode the request body using the proto.Marshal method.
+func WithEncodeProto() RequestOption {
+	return WithCodec(ProtoCodec{})
 }
 
 // WithEncodeMsgpack is a RequestOption to encode the request body using the msgpack.Marshal method.
 func WithEncodeMsgpack() RequestOption {
-	return encodeMsgpackOption(true)
+	return WithCodec(MsgpackCodec{})
 }
 
 // Decode de-serializes the body into the passed in object. The de-serialization method is based on
-// the request's Content-Type header.
+// the request's Content-Type header, see CodecFor. Bodies stored with WithLargePayloads are fetched,
+// and bodies compressed with WithCompression are decompressed first.
 func (r *Request) Decode(v any) error {
-	var err error
-
-	switch r.Header.Get("Content-Type") {
-	case "application/msgpack":
-		err = msgpack.Unmarshal(r.Data, v)
-	case "application/protobuf":
-		switch m := v.(type) {
-		case proto.Message:
-			err = proto.Unmarshal(r.Data, m)
-		default:
-			return fmt.Errorf("failed to decode proto message: invalid type :%T", v)
-		}
-	default:
-		err = json.Unmarshal(r.Data, v)
+	c, err := CodecFor(r.Header.Get(contentTypeHeader))
+	if err != nil {
+		return err
 	}
 
+	if err = r.payloads.load(r.Msg, false); err != nil {
+		return fmt.Errorf("failed to decode request: %w", err)
+	}
+
+	data, err := decompress(r.Header.Get(contentEncodingHeader), r.Data)
 	if err != nil {
 		return fmt.Errorf("failed to decode request: %w", err)
 	}
 
+	if err = c.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode request: %w", err)
+	}
+
 	return nil
 }
 
+// NewResponse constructs a new response to the request. The body is encoded with the Codec of the most preferred
+// content type in the request's Accept header that has a Codec registered, see WithAccept. If the request has no
+// Accept header or allows any content type, the body is encoded as configured by opts like with NewResponse.
+func (r *Request) NewResponse(body any, opts ...ResponseOption) (Response, error) {
+	if c, ok := negotiateCodec(r.Header.Get(acceptHeader)); ok {
+		opts = append(slices.Clip(opts), WithCodec(c))
+	}
+
+	return NewResponse(r.Reply, body, opts...)
+}
+
 // Subject returns the underlying nats.Msg subject.
 func (r *Request) Subject() string {
 	return r.Msg.Subject
 }
+
+// Attempt returns the attempt number of the request, starting at 1.
+// It is greater than 1 when the request is retried by a Client configured with a RetryPolicy.
+func (r *Request) Attempt() int {
+	return parseAttemptHeader(r.Header)
+}
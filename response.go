@@ -2,57 +2,36 @@
 package stormrpc
 
 import (
-	"encoding/json"
 	"fmt"
 
 	"github.com/nats-io/nats.go"
-	"github.com/vmihailenco/msgpack/v5"
-	"google.golang.org/protobuf/proto"
 )
 
 // Response is stormRPC's wrapper around a nats.Msg and is used by both clients and servers.
 type Response struct {
 	*nats.Msg
 	Err error
+
+	payloads *payloadStore
 }
 
 // NewResponse constructs a new response with the given parameters. It also handles encoding the response body.
 func NewResponse(reply string, body any, opts ...ResponseOption) (Response, error) {
 	options := requestOptions{
-		encodeProto:   false,
-		encodeMsgpack: false,
+		codec: JSONCodec{},
 	}
 
 	for _, o := range opts {
 		o.apply(&options)
 	}
 
-	var data []byte
-	var err error
-	var contentType string
-
-	switch {
-	case options.encodeProto:
-		switch m := body.(type) {
-		case proto.Message:
-			data, err = proto.Marshal(m)
-			contentType = "application/protobuf"
-		default:
-			return Response{}, fmt.Errorf("failed to encode proto message: invalid type: %T", m)
-		}
-	case options.encodeMsgpack:
-		data, err = msgpack.Marshal(body)
-		contentType = "application/msgpack"
-	default:
-		data, err = json.Marshal(body)
-		contentType = "application/json"
-	}
+	data, err := options.codec.Marshal(body)
 	if err != nil {
 		return Response{}, err
 	}
 
 	headers := nats.Header{}
-	headers.Set("Content-Type", contentType)
+	headers.Set(contentTypeHeader, options.codec.ContentType())
 
 	msg := &nats.Msg{
 		Subject: reply,
@@ -80,27 +59,35 @@ func NewErrorResponse(reply string, err error) Response {
 type ResponseOption RequestOption
 
 // Decode de-serializes the body into the passed in object. The de-serialization method is based on
-// the response's Content-Type header.
+// the response's Content-Type header, see CodecFor. Bodies stored with WithLargePayloads are fetched,
+// and bodies compressed with WithCompression are decompressed first.
 func (r *Response) Decode(v any) error {
-	var err error
-
-	switch r.Header.Get("Content-Type") {
-	case "application/msgpack":
-		err = msgpack.Unmarshal(r.Data, v)
-	case "application/protobuf":
-		switch m := v.(type) {
-		case proto.Message:
-			err = proto.Unmarshal(r.Data, m)
-		default:
-			return fmt.Errorf("failed to decode proto message: invalid type: %T", m)
-		}
-	default:
-		err = json.Unmarshal(r.Data, v)
+	c, err := CodecFor(r.Header.Get(contentTypeHeader))
+	if err != nil {
+		return err
 	}
 
+	if err = r.payloads.load(r.Msg, true); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	data, err := decompress(r.Header.Get(contentEncodingHeader), r.Data)
 	if err != nil {
 		return fmt.Errorf("failed to decode response: %w", err)
 	}
 
+	if err = c.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
 	return nil
 }
+
+// InstanceID returns the id of the server instance that sent the response, see Server.InstanceID.
+func (r *Response) InstanceID() string {
+	if r.Msg == nil {
+		return ""
+	}
+
+	return r.Header.Get(instanceIDHeader)
+}
@@ -0,0 +1,116 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"slices"
+	"time"
+)
+
+const (
+	defaultInitialBackoff    = 50 * time.Millisecond
+	defaultMaxBackoff        = 1 * time.Second
+	defaultBackoffMultiplier = 2
+)
+
+// RetryPolicy configures how a Client retries failed requests. Retries are made with exponential backoff
+// and stop once MaxAttempts is reached or the context passed to Client.Do is done.
+// Every attempt carries its number in a request header, available to servers through Request.Attempt.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one.
+	// Values lower than 2 disable retries.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. It defaults to 50ms.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts. It defaults to 1s.
+	MaxBackoff time.Duration
+	// BackoffMultiplier is the factor the delay grows by after every retry. It defaults to 2.
+	BackoffMultiplier float64
+	// Jitter randomizes every delay by up to the given fraction of it, e.g. 0.2 for ±20%.
+	Jitter float64
+	// PerAttemptTimeout bounds each attempt on its own, so requests that time out can be retried
+	// before the context passed to Client.Do expires.
+	PerAttemptTimeout time.Duration
+	// RetryableCodes are the error codes requests are retried on. It defaults to ErrorCodeUnavailable.
+	RetryableCodes []ErrorCode
+}
+
+func (p *RetryPolicy) enabled() bool {
+	return p != nil && p.MaxAttempts > 1
+}
+
+func (p *RetryPolicy) do(ctx context.Context, r Request, send func(context.Context, Request) Response) Response {
+	for attempt := 1; ; attempt++ {
+		setAttemptHeader(r.Header, attempt)
+
+		resp := p.attempt(ctx, r, send)
+		if resp.Err == nil || attempt >= p.MaxAttempts || !p.retryable(resp.Err) || ctx.Err() != nil {
+			return resp
+		}
+
+		delay := p.backoff(attempt, resp.Err)
+		if dl, ok := ctx.Deadline(); ok && time.Until(dl) < delay {
+			return resp
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return resp
+		case <-t.C:
+		}
+	}
+}
+
+func (p *RetryPolicy) attempt(ctx context.Context, r Request, send func(context.Context, Request) Response) Response {
+	if p.PerAttemptTimeout <= 0 {
+		return send(ctx, r)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.PerAttemptTimeout)
+	defer cancel()
+
+	return send(ctx, r)
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	code := CodeFromErr(err)
+	if len(p.RetryableCodes) == 0 {
+		return code == ErrorCodeUnavailable
+	}
+
+	return slices.Contains(p.RetryableCodes, code)
+}
+
+// backoff returns the delay before the given attempt is retried. A RetryInfo detail sent
+// by the server takes precedence over the policy.
+func (p *RetryPolicy) backoff(attempt int, err error) time.Duration {
+	for _, d := range ErrorDetails(err) {
+		if ri, ok := d.(RetryInfo); ok && ri.RetryDelay > 0 {
+			return ri.RetryDelay
+		}
+	}
+
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = defaultInitialBackoff
+	}
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	multiplier := p.BackoffMultiplier
+	if multiplier <= 0 {
+		multiplier = defaultBackoffMultiplier
+	}
+
+	d := math.Min(float64(initial)*math.Pow(multiplier, float64(attempt-1)), float64(maxBackoff))
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(d)
+}
@@ -0,0 +1,208 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		err     error
+		want    time.Duration
+	}{
+		{
+			name:    "defaults first retry",
+			policy:  RetryPolicy{},
+			attempt: 1,
+			err:     Errorf(ErrorCodeUnavailable, "unavailable"),
+			want:    50 * time.Millisecond,
+		},
+		{
+			name:    "defaults third retry",
+			policy:  RetryPolicy{},
+			attempt: 3,
+			err:     Errorf(ErrorCodeUnavailable, "unavailable"),
+			want:    200 * time.Millisecond,
+		},
+		{
+			name:    "capped by max backoff",
+			policy:  RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 3 * time.Second, BackoffMultiplier: 3},
+			attempt: 3,
+			err:     Errorf(ErrorCodeUnavailable, "unavailable"),
+			want:    3 * time.Second,
+		},
+		{
+			name:    "retry info from server",
+			policy:  RetryPolicy{},
+			attempt: 1,
+			err:     Errorf(ErrorCodeUnavailable, "unavailable").WithDetails(RetryInfo{RetryDelay: time.Second}),
+			want:    time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.backoff(tt.attempt, tt.err)
+			if got != tt.want {
+				t.Fatalf("got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("jitter", func(t *testing.T) {
+		p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, Jitter: 0.5}
+		for i := 0; i < 100; i++ {
+			got := p.backoff(1, errors.New("x"))
+			if got < 50*time.Millisecond || got > 150*time.Millisecond {
+				t.Fatalf("got = %v, want between %v and %v", got, 50*time.Millisecond, 150*time.Millisecond)
+			}
+		}
+	})
+}
+
+func TestClient_DoRetry(t *testing.T) {
+	clientURL := startNatsServer(t)
+
+	srv, err := NewServer(&ServerConfig{
+		NatsURL: clientURL,
+		Name:    "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls atomic.Int64
+	var lastAttempt atomic.Int64
+	srv.Handle("flaky", func(ctx context.Context, r Request) Response {
+		lastAttempt.Store(int64(r.Attempt()))
+		if calls.Add(1)%3 != 0 {
+			return NewErrorResponse(r.Reply, Errorf(ErrorCodeUnavailable, "try again"))
+		}
+		resp, _ := NewResponse(r.Reply, map[string]string{"hello": "world"})
+		return resp
+	})
+	srv.Handle("missing", func(ctx context.Context, r Request) Response {
+		calls.Add(1)
+		return NewErrorResponse(r.Reply, Errorf(ErrorCodeNotFound, "not found"))
+	})
+	srv.Handle("slow", func(ctx context.Context, r Request) Response {
+		if calls.Add(1) == 1 {
+			time.Sleep(100 * time.Millisecond)
+		}
+		resp, _ := NewResponse(r.Reply, map[string]string{"hello": "world"})
+		return resp
+	}, WithEndpointConcurrency(Concurrency{MaxInFlight: 2}))
+
+	go func() {
+		_ = srv.Run()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown(context.Background())
+	})
+
+	if !srv.ready(250 * time.Millisecond) {
+		t.Fatal("server not ready after 250 milliseconds")
+	}
+
+	client, err := NewClient(clientURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	t.Run("succeeds after retries", func(t *testing.T) {
+		calls.Store(0)
+		resp := client.Do(ctxWithTimeout(t, time.Second), mustNewRequest(t, "flaky", nil), WithRetry(policy))
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+
+		if got := lastAttempt.Load(); got != 3 {
+			t.Fatalf("got = %v, want %v", got, 3)
+		}
+	})
+
+	t.Run("max attempts exhausted", func(t *testing.T) {
+		calls.Store(0)
+		p := policy
+		p.MaxAttempts = 2
+		resp := client.Do(ctxWithTimeout(t, time.Second), mustNewRequest(t, "flaky", nil), WithRetry(p))
+		if CodeFromErr(resp.Err) != ErrorCodeUnavailable {
+			t.Fatalf("got = %v, want %v", CodeFromErr(resp.Err), ErrorCodeUnavailable)
+		}
+
+		if got := calls.Load(); got != 2 {
+			t.Fatalf("got = %v, want %v", got, 2)
+		}
+	})
+
+	t.Run("code not retryable", func(t *testing.T) {
+		calls.Store(0)
+		resp := client.Do(ctxWithTimeout(t, time.Second), mustNewRequest(t, "missing", nil), WithRetry(policy))
+		if CodeFromErr(resp.Err) != ErrorCodeNotFound {
+			t.Fatalf("got = %v, want %v", CodeFromErr(resp.Err), ErrorCodeNotFound)
+		}
+
+		if got := calls.Load(); got != 1 {
+			t.Fatalf("got = %v, want %v", got, 1)
+		}
+	})
+
+	t.Run("backoff exceeds deadline", func(t *testing.T) {
+		calls.Store(0)
+		p := policy
+		p.InitialBackoff = time.Second
+		start := time.Now()
+		resp := client.Do(ctxWithTimeout(t, 500*time.Millisecond), mustNewRequest(t, "flaky", nil), WithRetry(p))
+		if CodeFromErr(resp.Err) != ErrorCodeUnavailable {
+			t.Fatalf("got = %v, want %v", CodeFromErr(resp.Err), ErrorCodeUnavailable)
+		}
+
+		if time.Since(start) > 250*time.Millisecond {
+			t.Fatalf("expected request to return without waiting for the backoff, took %v", time.Since(start))
+		}
+	})
+
+	t.Run("per attempt timeout", func(t *testing.T) {
+		calls.Store(0)
+		p := policy
+		p.PerAttemptTimeout = 50 * time.Millisecond
+		p.RetryableCodes = []ErrorCode{ErrorCodeDeadlineExceeded}
+		resp := client.Do(ctxWithTimeout(t, time.Second), mustNewRequest(t, "slow", nil), WithRetry(p))
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+
+		if got := calls.Load(); got != 2 {
+			t.Fatalf("got = %v, want %v", got, 2)
+		}
+	})
+
+	t.Run("client default", func(t *testing.T) {
+		calls.Store(0)
+		client, err := NewClient(clientURL, WithRetry(policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(client.Close)
+
+		resp := client.Do(ctxWithTimeout(t, time.Second), mustNewRequest(t, "flaky", nil))
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+
+		calls.Store(0)
+		resp = client.Do(ctxWithTimeout(t, time.Second), mustNewRequest(t, "flaky", nil), WithRetry(RetryPolicy{}))
+		if CodeFromErr(resp.Err) != ErrorCodeUnavailable {
+			t.Fatalf("got = %v, want %v", CodeFromErr(resp.Err), ErrorCodeUnavailable)
+		}
+	})
+}
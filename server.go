@@ -3,11 +3,14 @@ package stormrpc
 
 import (
 	"context"
+	"errors"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	"github.com/nats-io/nats.go/micro"
 )
 
@@ -20,8 +23,13 @@ type ServerConfig struct {
 	Name    string
 	Version string
 
-	nc           *nats.Conn
-	errorHandler ErrorHandler
+	nc                *nats.Conn
+	errorHandler      ErrorHandler
+	concurrency       Concurrency
+	legacyErrorHeader bool
+	compression       *Compression
+	largePayloads     *LargePayloads
+	enforceDeadlines  bool
 }
 
 func (s *ServerConfig) setDefaults() {
@@ -45,13 +53,27 @@ type Server struct {
 	nc             *nats.Conn
 	shutdownSignal chan struct{}
 	handlerFuncs   map[string]HandlerFunc
+	endpoints      map[string]endpointOptions
 	errorHandler   ErrorHandler
 	timeout        time.Duration
 	mw             []Middleware
+	concurrency    Concurrency
+	legacyErrors   bool
+	compression    *Compression
+	payloads       *payloadStore
+	enforce        bool
+	abandoned      atomic.Int64
+	cancels        sync.Map // cancel id -> context.CancelFunc
+	pools          sync.Map // subject -> *workerPool
+	stats          sync.Map // subject -> *requestStats
+	inFlight       atomic.Int64
+	lastActive     atomic.Int64 // unix nanoseconds
 
 	running bool
 
-	svc micro.Service
+	svc       micro.Service
+	id        string
+	consumers []jetstream.ConsumeContext
 }
 
 // NewServer returns a new instance of a Server.
@@ -62,6 +84,12 @@ func NewServer(cfg *ServerConfig, opts ...ServerOption) (*Server, error) {
 		o.applyServer(cfg)
 	}
 
+	if cfg.compression != nil {
+		if err := cfg.compression.validate(); err != nil {
+			return nil, err
+		}
+	}
+
 	if cfg.nc == nil {
 		var err error
 		cfg.nc, err = nats.Connect(cfg.NatsURL)
@@ -82,20 +110,29 @@ func NewServer(cfg *ServerConfig, opts ...ServerOption) (*Server, error) {
 		}
 	}
 
-	svc, err := micro.AddService(cfg.nc, mc)
-	if err != nil {
-		return nil, err
-	}
-
-	return &Server{
+	srv := &Server{
 		nc:             cfg.nc,
 		shutdownSignal: make(chan struct{}),
 		handlerFuncs:   make(map[string]HandlerFunc),
 		timeout:        defaultServerTimeout,
 		errorHandler:   cfg.errorHandler,
+		concurrency:    cfg.concurrency,
+		legacyErrors:   cfg.legacyErrorHeader,
+		compression:    cfg.compression,
+		payloads:       newPayloadStore(cfg.nc, cfg.largePayloads),
+		enforce:        cfg.enforceDeadlines,
 		running:        false,
-		svc:            svc,
-	}, nil
+	}
+	mc.StatsHandler = srv.endpointStats
+
+	svc, err := micro.AddService(cfg.nc, mc)
+	if err != nil {
+		return nil, err
+	}
+	srv.svc = svc
+	srv.id = svc.Info().ID
+
+	return srv, nil
 }
 
 // HandlerFunc is the function signature for handling of a single request to a stormRPC server.
@@ -107,51 +144,167 @@ type Middleware func(next HandlerFunc) HandlerFunc
 // ErrorHandler is the function signature for handling server errors.
 type ErrorHandler func(context.Context, error)
 
-// Handle registers a new HandlerFunc on the server.
-func (s *Server) Handle(subject string, fn HandlerFunc) {
+// Handle registers a new HandlerFunc on the server. EndpointOptions can be
+// used to configure the endpoint independently of the other endpoints.
+func (s *Server) Handle(subject string, fn HandlerFunc, opts ...EndpointOption) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	var options endpointOptions
+	for _, o := range opts {
+		o.applyEndpoint(&options)
+	}
+
+	if s.endpoints == nil {
+		s.endpoints = make(map[string]endpointOptions)
+	}
+
 	s.handlerFuncs[subject] = fn
+	s.endpoints[subject] = options
 }
 
 // Run listens on the configured subjects.
 func (s *Server) Run() error {
+	if err := s.start(); err != nil {
+		return err
+	}
+
+	<-s.shutdownSignal
+	return nil
+}
+
+func (s *Server) start() error {
 	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	s.applyMiddlewares()
 
+	if err := s.subscribeCancels(); err != nil {
+		return err
+	}
+
 	for sub, fn := range s.handlerFuncs {
+		if s.endpoints[sub].workQueue != nil {
+			if err := s.createWorkQueueConsumer(sub, fn); err != nil {
+				return err
+			}
+			continue
+		}
+
 		if err := s.createMicroEndpoint(sub, fn); err != nil {
 			return err
 		}
 	}
 
 	s.running = true
-	s.mu.Unlock()
-
-	<-s.shutdownSignal
 	return nil
 }
 
-// Shutdown stops the server.
+// Shutdown gracefully stops the server. It stops accepting new requests, waits for requests
+// that were already received to finish, flushes their replies and closes the connection.
+// If ctx is done before all requests have finished the connection is closed anyway and
+// ctx's error is returned.
 func (s *Server) Shutdown(ctx context.Context) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.stopConsumers(ctx)
 
 	if err := s.svc.Stop(); err != nil {
 		return err
 	}
+	s.lastActive.Store(time.Now().UnixNano())
 
-	if err := s.nc.FlushWithContext(ctx); err != nil {
-		return err
+	drainErr := s.drain(ctx)
+
+	s.mu.Lock()
+	s.pools.Range(func(_, p any) bool {
+		if pool, ok := p.(*workerPool); ok {
+			pool.stop()
+		}
+		return true
+	})
+	s.mu.Unlock()
+
+	if drainErr == nil {
+		if err := s.flush(ctx); err != nil {
+			return err
+		}
 	}
 
 	s.nc.Close()
+
+	s.mu.Lock()
 	s.running = false
+	s.mu.Unlock()
+
 	s.shutdownSignal <- struct{}{}
+	return drainErr
+}
+
+// InFlight returns the number of requests that are currently being handled or are
+// waiting for a worker.
+func (s *Server) InFlight() int {
+	return int(s.inFlight.Load())
+}
+
+// drainSettlePeriod is how long no request must have been accepted or finished before the
+// service's subscriptions are considered drained.
+var drainSettlePeriod = 100 * time.Millisecond
+
+// drain blocks until all in-flight requests have finished, or until ctx is done.
+//
+// Stopping the service drains its subscriptions in the background, which still dispatches the
+// requests that were pending on them. Once no request has been accepted or finished for the
+// settle period after the service was stopped, there are no pending requests left.
+func (s *Server) drain(ctx context.Context) error {
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+
+	drained := func() bool {
+		if s.inFlight.Load() > 0 {
+			return false
+		}
+		return time.Since(time.Unix(0, s.lastActive.Load())) >= drainSettlePeriod
+	}
+
+	for !drained() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+
 	return nil
 }
 
+// accept records that one of the server's endpoints accepted a request.
+// It must be followed by a call to finish once the request has been handled.
+func (s *Server) accept() {
+	s.lastActive.Store(time.Now().UnixNano())
+	s.inFlight.Add(1)
+}
+
+// finish records that a request accepted with accept has been handled.
+func (s *Server) finish() {
+	// Updated before the request stops being in flight, so drain never observes
+	// no requests in flight together with a stale activity time.
+	s.lastActive.Store(time.Now().UnixNano())
+	s.inFlight.Add(-1)
+}
+
+func (s *Server) flush(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return s.nc.Flush()
+	}
+
+	return s.nc.FlushWithContext(ctx)
+}
+
+// InstanceID returns the unique id of the server instance. Replies sent by the server carry it,
+// so responses collected with Client.DoAll can be told apart.
+func (s *Server) InstanceID() string {
+	return s.id
+}
+
 // Subjects returns a list of all subjects with registered handler funcs.
 func (s *Server) Subjects() []string {
 	s.mu.Lock()
@@ -177,6 +330,11 @@ func (s *Server) Use(mw ...Middleware) {
 
 func (s *Server) applyMiddlewares() {
 	for k, hf := range s.handlerFuncs {
+		mw := s.endpoints[k].mw
+		for i := len(mw) - 1; i >= 0; i-- {
+			hf = mw[i](hf)
+		}
+
 		for i := len(s.mw) - 1; i >= 0; i-- {
 			hf = s.mw[i](hf)
 		}
@@ -188,53 +346,276 @@ func (s *Server) applyMiddlewares() {
 // createMicroEndpoint registers a HandlerFunc as a micro Endpoint
 // allowing for automatic service discovery and observability.
 func (s *Server) createMicroEndpoint(subject string, handlerFunc HandlerFunc) error {
+	opts := s.endpoints[subject]
+
+	timeout := s.timeout
+	if opts.stream {
+		timeout = 0
+	}
+	if opts.timeout > 0 {
+		timeout = opts.timeout
+	}
+
+	// Open streams would hold on to the workers of the server's pools, so
+	// stream endpoints only use a pool if they're configured with their own.
+	concurrency := s.concurrency
+	if opts.stream {
+		concurrency = Concurrency{}
+	}
+	if opts.concurrency != nil {
+		concurrency = *opts.concurrency
+	}
+
+	enforce := s.enforce && !opts.stream
+
+	var handler func(ctx context.Context, r micro.Request)
+	switch {
+	case concurrency.enabled():
+		pool := newWorkerPool(concurrency)
+		stats := &requestStats{}
+		s.pools.Store(subject, pool)
+		s.stats.Store(subject, stats)
+
+		handler = func(ctx context.Context, r micro.Request) {
+			received := time.Now()
+			ctx, done := s.cancelable(ctx, nats.Header(r.Headers()))
+			s.accept()
+			ok := pool.submit(func() {
+				defer s.finish()
+				defer done()
+
+				start := time.Now()
+				err := s.handleRequest(ctx, r, handlerFunc, timeout, enforce, received)
+				stats.record(start, err)
+			})
+			if !ok {
+				stats.reject()
+				_ = s.respond(ctx, r, NewErrorResponse(
+					r.Reply(),
+					Errorf(ErrorCodeResourceExhausted, "too many requests in flight for subject: %s", subject),
+				))
+				s.finish()
+				done()
+			}
+		}
+	case opts.stream:
+		// Streams can stay open for a long time, so each one gets its own
+		// goroutine instead of blocking the endpoint's subscription.
+		stats := &requestStats{}
+		s.stats.Store(subject, stats)
+
+		handler = func(ctx context.Context, r micro.Request) {
+			received := time.Now()
+			ctx, done := s.cancelable(ctx, nats.Header(r.Headers()))
+			s.accept()
+			go func() {
+				defer s.finish()
+				defer done()
+
+				start := time.Now()
+				err := s.handleRequest(ctx, r, handlerFunc, timeout, enforce, received)
+				stats.record(start, err)
+			}()
+		}
+	default:
+		// Requests are handed off to a queue as soon as they arrive, so the time they spend
+		// waiting for the requests ahead of them counts against their deadline and they
+		// can be cancelled while waiting.
+		queue := &fifo{}
+		stats := &requestStats{}
+		s.stats.Store(subject, stats)
+
+		handler = func(ctx context.Context, r micro.Request) {
+			received := time.Now()
+			ctx, done := s.cancelable(ctx, nats.Header(r.Headers()))
+			s.accept()
+			queue.submit(func() {
+				defer s.finish()
+				defer done()
+
+				start := time.Now()
+				err := s.handleRequest(ctx, r, handlerFunc, timeout, enforce, received)
+				stats.record(start, err)
+			})
+		}
+	}
+
+	microOpts := []micro.EndpointOpt{micro.WithEndpointSubject(subject)}
+	if opts.queueGroup != "" {
+		microOpts = append(microOpts, micro.WithEndpointQueueGroup(opts.queueGroup))
+	}
+	if opts.noQueueGroup {
+		microOpts = append(microOpts, micro.WithEndpointQueueGroupDisabled())
+	}
+	if len(opts.metadata) > 0 {
+		microOpts = append(microOpts, micro.WithEndpointMetadata(opts.metadata))
+	}
+
 	return s.svc.AddEndpoint(
 		nameFromSubject(subject),
-		micro.ContextHandler(context.Background(), func(ctx context.Context, r micro.Request) {
-			ctx, cancel := context.WithCancel(ctx)
-			defer cancel()
+		micro.ContextHandler(context.Background(), handler),
+		microOpts...,
+	)
+}
 
-			ctx = newContextWithHeaders(ctx, nats.Header(r.Headers()))
+// handleRequest runs the HandlerFunc for a single request and responds with its Response.
+// The returned error is the error the request failed with, if any. Requests whose deadline
+// passed or that were canceled by their client before they were handled fail without running
+// the HandlerFunc. If enforce is true, the request fails as soon as its context is done, see WithEnforcedDeadlines.
+func (s *Server) handleRequest(
+	ctx context.Context,
+	r micro.Request,
+	handlerFunc HandlerFunc,
+	timeout time.Duration,
+	enforce bool,
+	received time.Time,
+) error {
+	header := nats.Header(r.Headers())
+	deadline := requestDeadline(header, received)
+	ctx, cancel := s.newRequestContext(ctx, header, deadline, timeout)
+	defer cancel()
+
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return s.respond(ctx, r, NewErrorResponse(
+			r.Reply(),
+			Errorf(ErrorCodeDeadlineExceeded, "deadline exceeded before the request was handled"),
+		))
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return s.respond(ctx, r, NewErrorResponse(
+			r.Reply(),
+			Errorf(ErrorCodeCanceled, "request was canceled before it was handled"),
+		))
+	}
 
-			dl := parseDeadlineHeader(nats.Header(r.Headers()))
-			if !dl.IsZero() { // if deadline is present use it
-				ctx, cancel = context.WithDeadline(ctx, dl)
-				defer cancel()
-			} else {
-				ctx, cancel = context.WithTimeout(ctx, s.timeout)
-				defer cancel()
-			}
+	req := Request{
+		Msg: &nats.Msg{
+			Subject: r.Subject(),
+			Reply:   r.Reply(),
+			Header:  nats.Header(r.Headers()),
+			Data:    r.Data(),
+		},
+		payloads: s.payloads,
+	}
 
-			resp := handlerFunc(ctx, Request{
-				Msg: &nats.Msg{
-					Subject: r.Subject(),
-					Header:  nats.Header(r.Headers()),
-					Data:    r.Data(),
-				},
-			})
+	if !enforce {
+		return s.respond(ctx, r, handlerFunc(ctx, req))
+	}
 
-			if resp.Err != nil {
-				if resp.Header == nil {
-					resp.Header = nats.Header{}
-				}
-				setErrorHeader(resp.Header, resp.Err)
-
-				err := r.Error(
-					CodeFromErr(resp.Err).String(),
-					MessageFromErr(resp.Err),
-					nil,
-					micro.WithHeaders(micro.Headers(resp.Header)),
-				)
-				if err != nil {
-					s.errorHandler(ctx, err)
-				}
-			}
+	done := make(chan Response, 1)
+	go func() {
+		done <- handlerFunc(ctx, req)
+	}()
 
-			err := r.Respond(resp.Data, micro.WithHeaders(micro.Headers(resp.Header)))
-			if err != nil {
-				s.errorHandler(ctx, err)
-			}
-		}), micro.WithEndpointSubject(subject))
+	select {
+	case resp := <-done:
+		return s.respond(ctx, r, resp)
+	case <-ctx.Done():
+	}
+
+	s.abandoned.Add(1)
+	err := s.respond(ctx, r, NewErrorResponse(r.Reply(), requestError(ctx.Err(), r.Subject())))
+	s.errorHandler(ctx, Errorf(
+		ErrorCodeDeadlineExceeded,
+		"handler for subject %s didn't return before its context was done: %v",
+		r.Subject(),
+		ctx.Err(),
+	))
+
+	// The handler is still counted as in flight until it returns, its Response is discarded.
+	<-done
+
+	return err
+}
+
+// AbandonedHandlers returns the number of requests that were replied to before their handler
+// returned because their deadline passed, see WithEnforcedDeadlines.
+func (s *Server) AbandonedHandlers() int64 {
+	return s.abandoned.Load()
+}
+
+// newRequestContext returns the context a HandlerFunc is called with. The deadline takes
+// precedence over the timeout, a zero deadline and timeout leave the context without one.
+func (s *Server) newRequestContext(
+	ctx context.Context,
+	header nats.Header,
+	deadline time.Time,
+	timeout time.Duration,
+) (context.Context, context.CancelFunc) {
+	ctx = newContextWithHeaders(ctx, header)
+	ctx = newContextWithServer(ctx, s)
+	ctx = newContextWithResponseHeaders(ctx)
+
+	switch {
+	case !deadline.IsZero():
+		return context.WithDeadline(ctx, deadline)
+	case timeout > 0:
+		return context.WithTimeout(ctx, timeout)
+	default:
+		return context.WithCancel(ctx)
+	}
+}
+
+// respond sends the Response to the caller of the request. It returns the Response's error,
+// or the error that occurred sending the Response.
+func (s *Server) respond(ctx context.Context, r micro.Request, resp Response) error {
+	// Events have no caller to respond to, so their errors go to the error handler.
+	if r.Reply() == "" {
+		if resp.Err != nil {
+			s.errorHandler(ctx, resp.Err)
+		}
+		return resp.Err
+	}
+
+	if resp.Header == nil {
+		resp.Header = nats.Header{}
+	}
+	mergeResponseHeaders(ctx, resp.Header)
+	if s.id != "" {
+		resp.Header.Set(instanceIDHeader, s.id)
+	}
+	s.compressResponse(ctx, nats.Header(r.Headers()), &resp)
+	if _, err := s.payloads.store(ctx, resp.Msg); err != nil {
+		s.errorHandler(ctx, err)
+		resp.Data = nil
+		resp.Err = err
+	}
+
+	if resp.Err != nil {
+		setErrorHeader(resp.Header, resp.Err, s.legacyErrors)
+
+		code, description := serviceError(resp.Err)
+		err := r.Error(code, description, resp.Data, micro.WithHeaders(micro.Headers(resp.Header)))
+		if err != nil {
+			s.errorHandler(ctx, err)
+			return err
+		}
+
+		return resp.Err
+	}
+
+	err := r.Respond(resp.Data, micro.WithHeaders(micro.Headers(resp.Header)))
+	if err != nil {
+		s.errorHandler(ctx, err)
+		return err
+	}
+
+	return nil
+}
+
+// endpointStats returns the ConcurrencyStats of an endpoint to be included in its micro stats.
+func (s *Server) endpointStats(e *micro.Endpoint) any {
+	v, ok := s.stats.Load(e.Subject)
+	if !ok {
+		return nil
+	}
+
+	stats, ok := v.(*requestStats)
+	if !ok {
+		return nil
+	}
+
+	return stats.get()
 }
 
 func (s *Server) ready(dur time.Duration) bool {
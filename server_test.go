@@ -3,13 +3,17 @@ package stormrpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
 )
 
 type testErrorHandler struct {
@@ -181,6 +185,283 @@ func TestServer_RunAndShutdown(t *testing.T) {
 	}
 }
 
+func TestServer_ShutdownDrain(t *testing.T) {
+	clientURL := startNatsServer(t)
+
+	newServer := func(t *testing.T, subject string, started chan struct{}, release chan struct{}) *Server {
+		t.Helper()
+
+		srv, err := NewServer(&ServerConfig{
+			NatsURL: clientURL,
+			Name:    "test",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		srv.Handle(subject, func(ctx context.Context, r Request) Response {
+			started <- struct{}{}
+			<-release
+			return Response{Msg: &nats.Msg{Subject: r.Reply}}
+		})
+
+		go func() {
+			_ = srv.Run()
+		}()
+
+		if !srv.ready(250 * time.Millisecond) {
+			t.Fatal("server not ready after 250 milliseconds")
+		}
+
+		return srv
+	}
+
+	client, err := NewClient(clientURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	t.Run("waits for pending and in flight requests", func(t *testing.T) {
+		started := make(chan struct{}, 3)
+		release := make(chan struct{})
+		srv := newServer(t, "drain", started, release)
+
+		reqs := make([]Request, 3)
+		for i := range reqs {
+			reqs[i] = mustNewRequest(t, "drain", i)
+		}
+
+		ctx := ctxWithTimeout(t, 2*time.Second)
+		respCh := make(chan Response, len(reqs))
+		for _, r := range reqs {
+			go func(r Request) {
+				respCh <- client.Do(ctx, r)
+			}(r)
+		}
+		<-started
+
+		// Requests waiting behind the first one are in flight too.
+		for deadline := time.Now().Add(time.Second); srv.InFlight() != len(reqs); {
+			if time.Now().After(deadline) {
+				t.Fatalf("got = %v, want %v", srv.InFlight(), len(reqs))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		shutdownCh := make(chan error, 1)
+		go func() {
+			shutdownCh <- srv.Shutdown(ctx)
+		}()
+
+		select {
+		case err := <-shutdownCh:
+			t.Fatalf("expected shutdown to wait for in flight request, got %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		if subs := srv.Subjects(); len(subs) != 1 {
+			t.Fatalf("got = %v, want %v", len(subs), 1)
+		}
+
+		close(release)
+
+		if err := <-shutdownCh; err != nil {
+			t.Fatal(err)
+		}
+		if got := srv.InFlight(); got != 0 {
+			t.Fatalf("got = %v, want %v", got, 0)
+		}
+
+		for range reqs {
+			resp := <-respCh
+			if resp.Err != nil {
+				t.Fatalf("got = %v, want nil error", resp.Err)
+			}
+		}
+	})
+
+	t.Run("connection shared with a client", func(t *testing.T) {
+		echo, err := client.nc.Subscribe("drain_shared_echo", func(msg *nats.Msg) {
+			_ = msg.Respond(nil)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = echo.Unsubscribe()
+		})
+
+		nc, err := nats.Connect(clientURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shared, err := NewClient("", WithNatsConn(nc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv, err := NewServer(&ServerConfig{
+			NatsURL: clientURL,
+			Name:    "test",
+		}, WithNatsConn(nc))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		started := make(chan struct{}, 1)
+		release := make(chan struct{})
+		srv.Handle("drain_shared", func(ctx context.Context, r Request) Response {
+			started <- struct{}{}
+			<-release
+			// The first request made with the connection subscribes to its response inbox.
+			resp := shared.Do(ctx, mustNewRequest(t, "drain_shared_echo", nil))
+			if resp.Err != nil {
+				return NewErrorResponse(r.Reply, resp.Err)
+			}
+			return Response{Msg: &nats.Msg{Subject: r.Reply}}
+		})
+		go func() {
+			_ = srv.Run()
+		}()
+		if !srv.ready(250 * time.Millisecond) {
+			t.Fatal("server not ready after 250 milliseconds")
+		}
+
+		ctx := ctxWithTimeout(t, 2*time.Second)
+		respCh := make(chan Response, 1)
+		go func() {
+			respCh <- client.Do(ctx, mustNewRequest(t, "drain_shared", nil))
+		}()
+		<-started
+
+		shutdownCh := make(chan error, 1)
+		go func() {
+			shutdownCh <- srv.Shutdown(ctx)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+
+		select {
+		case err := <-shutdownCh:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("shutdown didn't return after the in flight request finished")
+		}
+		if resp := <-respCh; resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+	})
+
+	t.Run("context expires before requests finish", func(t *testing.T) {
+		started := make(chan struct{}, 1)
+		release := make(chan struct{})
+		defer close(release)
+		srv := newServer(t, "drain_timeout", started, release)
+
+		r := mustNewRequest(t, "drain_timeout", 0)
+		ctx := ctxWithTimeout(t, 1*time.Second)
+		go func() {
+			_ = client.Do(ctx, r)
+		}()
+		<-started
+
+		err := srv.Shutdown(ctxWithTimeout(t, 50*time.Millisecond))
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got = %v, want %v", err, context.DeadlineExceeded)
+		}
+		if !srv.nc.IsClosed() {
+			t.Fatal("expected connection to be closed")
+		}
+	})
+}
+
+func TestServer_ErrorReply(t *testing.T) {
+	clientURL := startNatsServer(t)
+
+	nc, err := nats.Connect(clientURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+
+	tests := []struct {
+		name       string
+		subject    string
+		opts       []ServerOption
+		wantLegacy string
+	}{
+		{
+			name:    "micro error headers",
+			subject: "error",
+		},
+		{
+			name:       "legacy error header",
+			subject:    "legacy_error",
+			opts:       []ServerOption{WithLegacyErrorHeader()},
+			wantLegacy: "STORMRPC_CODE_NOT_FOUND: thingy: not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServer(&ServerConfig{
+				NatsURL: clientURL,
+				Name:    "test",
+			}, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv.Handle(tt.subject, func(ctx context.Context, r Request) Response {
+				return NewErrorResponse(r.Reply, Errorf(ErrorCodeNotFound, "thingy: not found"))
+			})
+			go func() {
+				_ = srv.Run()
+			}()
+			t.Cleanup(func() {
+				_ = srv.Shutdown(context.Background())
+			})
+			if !srv.ready(250 * time.Millisecond) {
+				t.Fatal("server not ready after 250 milliseconds")
+			}
+
+			inbox := nats.NewInbox()
+			sub, err := nc.SubscribeSync(inbox)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = sub.Unsubscribe()
+			}()
+
+			if err = nc.PublishRequest(tt.subject, inbox, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			msg, err := sub.NextMsg(1 * time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := msg.Header.Get(micro.ErrorHeader); got != "thingy: not found" {
+				t.Errorf("got = %v, want %v", got, "thingy: not found")
+			}
+			if got := msg.Header.Get(micro.ErrorCodeHeader); got != "404" {
+				t.Errorf("got = %v, want %v", got, "404")
+			}
+			if got := msg.Header.Get(errorStatusHeader); got == "" {
+				t.Errorf("got = %v, want the exact error code in the status header", got)
+			}
+			if got := msg.Header.Get(errorHeader); got != tt.wantLegacy {
+				t.Errorf("got = %v, want %v", got, tt.wantLegacy)
+			}
+
+			if _, err = sub.NextMsg(100 * time.Millisecond); !errors.Is(err, nats.ErrTimeout) {
+				t.Fatalf("expected a single reply got = %v", err)
+			}
+		})
+	}
+}
+
 func TestServer_Run(t *testing.T) {
 	type args struct {
 		ctx context.Context
@@ -326,6 +607,564 @@ func TestServer_Handle(t *testing.T) {
 	}
 }
 
+func TestServer_DeadlinePropagation(t *testing.T) {
+	clientURL := startNatsServer(t)
+
+	srv, err := NewServer(&ServerConfig{
+		NatsURL: clientURL,
+		Name:    "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls atomic.Int64
+	srv.Handle("budget", func(ctx context.Context, r Request) Response {
+		dl, ok := ctx.Deadline()
+		if !ok {
+			return NewErrorResponse(r.Reply, fmt.Errorf("context should have deadline"))
+		}
+		if time.Until(dl) > 300*time.Millisecond {
+			return NewErrorResponse(r.Reply, fmt.Errorf("got deadline in %v, want at most %v", time.Until(dl), 300*time.Millisecond))
+		}
+		return Response{Msg: &nats.Msg{Subject: r.Reply}}
+	})
+	srv.Handle("queued", func(ctx context.Context, r Request) Response {
+		calls.Add(1)
+		time.Sleep(200 * time.Millisecond)
+		return Response{Msg: &nats.Msg{Subject: r.Reply}}
+	}, WithEndpointConcurrency(Concurrency{MaxInFlight: 1, QueueSize: 1}))
+	var serialCalls atomic.Int64
+	srv.Handle("serial", func(ctx context.Context, r Request) Response {
+		serialCalls.Add(1)
+		time.Sleep(200 * time.Millisecond)
+		return Response{Msg: &nats.Msg{Subject: r.Reply}}
+	})
+
+	go func() {
+		_ = srv.Run()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown(context.Background())
+	})
+
+	if !srv.ready(250 * time.Millisecond) {
+		t.Fatal("server not ready after 250 milliseconds")
+	}
+
+	client, err := NewClient(clientURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	t.Run("sub-second budget", func(t *testing.T) {
+		resp := client.Do(ctxWithTimeout(t, 300*time.Millisecond), mustNewRequest(t, "budget", 1))
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+	})
+
+	t.Run("skewed absolute deadline is ignored", func(t *testing.T) {
+		req := mustNewRequest(t, "budget", 1)
+		req.Header.Set(timeoutHeader, "200")
+		req.Header.Set(deadlineHeader, time.Now().Add(time.Hour).Format(time.RFC3339Nano))
+
+		msg, err := client.nc.RequestMsg(req.Msg, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rpcErr := parseErrorHeader(msg.Header); rpcErr != nil {
+			t.Fatal(rpcErr)
+		}
+	})
+
+	t.Run("exhausted on arrival", func(t *testing.T) {
+		req := mustNewRequest(t, "queued", 1)
+		req.Header.Set(timeoutHeader, "0")
+
+		msg, err := client.nc.RequestMsg(req.Msg, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code := CodeFromErr(parseErrorHeader(msg.Header)); code != ErrorCodeDeadlineExceeded {
+			t.Fatalf("got = %v, want %v", code, ErrorCodeDeadlineExceeded)
+		}
+		if got := calls.Load(); got != 0 {
+			t.Fatalf("got = %v, want %v", got, 0)
+		}
+	})
+
+	t.Run("exhausted while queued", func(t *testing.T) {
+		calls.Store(0)
+		first := mustNewRequest(t, "queued", 1)
+		second := mustNewRequest(t, "queued", 1)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			client.Do(ctxWithTimeout(t, time.Second), first)
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		resp := client.Do(ctxWithTimeout(t, 100*time.Millisecond), second)
+		if CodeFromErr(resp.Err) != ErrorCodeDeadlineExceeded {
+			t.Fatalf("got = %v, want %v", CodeFromErr(resp.Err), ErrorCodeDeadlineExceeded)
+		}
+
+		<-done
+		// Give the server time to reject the queued request.
+		time.Sleep(100 * time.Millisecond)
+		if got := calls.Load(); got != 1 {
+			t.Fatalf("got = %v, want %v", got, 1)
+		}
+	})
+
+	t.Run("exhausted while waiting behind a slow request", func(t *testing.T) {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			client.Do(ctxWithTimeout(t, time.Second), mustNewRequest(t, "serial", 1))
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		resp := client.Do(ctxWithTimeout(t, 100*time.Millisecond), mustNewRequest(t, "serial", 1))
+		if CodeFromErr(resp.Err) != ErrorCodeDeadlineExceeded {
+			t.Fatalf("got = %v, want %v", CodeFromErr(resp.Err), ErrorCodeDeadlineExceeded)
+		}
+
+		<-done
+		// Give the server time to reject the waiting request.
+		time.Sleep(100 * time.Millisecond)
+		if got := serialCalls.Load(); got != 1 {
+			t.Fatalf("got = %v, want %v", got, 1)
+		}
+	})
+}
+
+func TestServer_EnforcedDeadlines(t *testing.T) {
+	clientURL := startNatsServer(t)
+
+	errs := make(chan error, 1)
+	srv, err := NewServer(&ServerConfig{
+		NatsURL: clientURL,
+		Name:    "test",
+	}, WithEnforcedDeadlines(), WithErrorHandler(func(ctx context.Context, err error) {
+		errs <- err
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Handle("slow", func(ctx context.Context, r Request) Response {
+		// Ignores ctx on purpose.
+		time.Sleep(300 * time.Millisecond)
+		return Response{Msg: &nats.Msg{Subject: r.Reply}}
+	}, WithEndpointTimeout(50*time.Millisecond))
+	srv.Handle("fast", func(ctx context.Context, r Request) Response {
+		resp, _ := NewResponse(r.Reply, map[string]string{"hello": "world"})
+		return resp
+	}, WithEndpointTimeout(50*time.Millisecond))
+
+	go func() {
+		_ = srv.Run()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown(context.Background())
+	})
+
+	if !srv.ready(250 * time.Millisecond) {
+		t.Fatal("server not ready after 250 milliseconds")
+	}
+
+	client, err := NewClient(clientURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	t.Run("handler returns in time", func(t *testing.T) {
+		resp := client.Do(ctxWithTimeout(t, time.Second), mustNewRequest(t, "fast", nil))
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+	})
+
+	t.Run("handler abandoned", func(t *testing.T) {
+		sub, err := client.nc.SubscribeSync(client.nc.NewRespInbox())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = sub.Unsubscribe()
+		})
+
+		req := mustNewRequest(t, "slow", nil)
+		req.Reply = sub.Subject
+
+		start := time.Now()
+		if err = client.nc.PublishMsg(req.Msg); err != nil {
+			t.Fatal(err)
+		}
+
+		msg, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code := CodeFromErr(parseErrorHeader(msg.Header)); code != ErrorCodeDeadlineExceeded {
+			t.Fatalf("got = %v, want %v", code, ErrorCodeDeadlineExceeded)
+		}
+		if elapsed := time.Since(start); elapsed >= 300*time.Millisecond {
+			t.Fatalf("got reply after %v, want it before the handler returned", elapsed)
+		}
+
+		select {
+		case err := <-errs:
+			if CodeFromErr(err) != ErrorCodeDeadlineExceeded {
+				t.Fatalf("got = %v, want %v", CodeFromErr(err), ErrorCodeDeadlineExceeded)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for error")
+		}
+
+		// The handler's late reply is suppressed.
+		if _, err = sub.NextMsg(500 * time.Millisecond); !errors.Is(err, nats.ErrTimeout) {
+			t.Fatalf("got = %v, want %v", err, nats.ErrTimeout)
+		}
+
+		if got := srv.AbandonedHandlers(); got != 1 {
+			t.Fatalf("got = %v, want %v", got, 1)
+		}
+	})
+}
+
+func TestServer_Cancellation(t *testing.T) {
+	clientURL := startNatsServer(t)
+
+	srv, err := NewServer(&ServerConfig{
+		NatsURL: clientURL,
+		Name:    "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	canceled := make(chan error, 1)
+	srv.Handle("wait", func(ctx context.Context, r Request) Response {
+		<-ctx.Done()
+		canceled <- ctx.Err()
+		return NewErrorResponse(r.Reply, ctx.Err())
+	}, WithEndpointTimeout(5*time.Second))
+
+	// The first request to each endpoint blocks until released.
+	type blockingEndpoint struct {
+		release chan struct{}
+		handled atomic.Int64
+	}
+	endpoints := map[string]*blockingEndpoint{
+		"queued": {release: make(chan struct{})},
+		"serial": {release: make(chan struct{})},
+	}
+	blockFirst := func(e *blockingEndpoint) HandlerFunc {
+		return func(ctx context.Context, r Request) Response {
+			if e.handled.Add(1) == 1 {
+				<-e.release
+			}
+			return Response{Msg: &nats.Msg{Subject: r.Reply}}
+		}
+	}
+	srv.Handle("queued", blockFirst(endpoints["queued"]), WithEndpointConcurrency(Concurrency{MaxInFlight: 1, QueueSize: 1}))
+	srv.Handle("serial", blockFirst(endpoints["serial"]))
+
+	var early atomic.Int64
+	srv.Handle("early", func(ctx context.Context, r Request) Response {
+		early.Add(1)
+		return Response{Msg: &nats.Msg{Subject: r.Reply}}
+	})
+
+	go func() {
+		_ = srv.Run()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown(context.Background())
+	})
+
+	if !srv.ready(250 * time.Millisecond) {
+		t.Fatal("server not ready after 250 milliseconds")
+	}
+
+	client, err := NewClient(clientURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	t.Run("handler context canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+
+		resp := client.Do(ctx, mustNewRequest(t, "wait", nil))
+		if CodeFromErr(resp.Err) != ErrorCodeCanceled {
+			t.Fatalf("got = %v, want %v", CodeFromErr(resp.Err), ErrorCodeCanceled)
+		}
+
+		select {
+		case err := <-canceled:
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("got = %v, want %v", err, context.Canceled)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("handler context wasn't canceled")
+		}
+	})
+
+	for _, subject := range []string{"queued", "serial"} {
+		t.Run("canceled while queued on "+subject+" endpoint", func(t *testing.T) {
+			e := endpoints[subject]
+
+			first := make(chan Response, 1)
+			go func() {
+				first <- client.Do(ctxWithTimeout(t, 5*time.Second), mustNewRequest(t, subject, nil))
+			}()
+			time.Sleep(100 * time.Millisecond)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(100*time.Millisecond, cancel)
+
+			resp := client.Do(ctx, mustNewRequest(t, subject, nil))
+			if CodeFromErr(resp.Err) != ErrorCodeCanceled {
+				t.Fatalf("got = %v, want %v", CodeFromErr(resp.Err), ErrorCodeCanceled)
+			}
+
+			// Give the cancel notice time to arrive before the queued request is dequeued.
+			time.Sleep(100 * time.Millisecond)
+			close(e.release)
+
+			if resp := <-first; resp.Err != nil {
+				t.Fatal(resp.Err)
+			}
+			time.Sleep(100 * time.Millisecond)
+			if got := e.handled.Load(); got != 1 {
+				t.Fatalf("got = %v, want %v", got, 1)
+			}
+		})
+	}
+
+	t.Run("notice arrives before the request", func(t *testing.T) {
+		id := uuid.NewString()
+		client.cancel(id)
+		if err := client.nc.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+
+		req := mustNewRequest(t, "early", nil)
+		req.Header.Set(cancelIDHeader, id)
+		msg, err := client.nc.RequestMsg(req.Msg, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code := CodeFromErr(parseErrorHeader(msg.Header)); code != ErrorCodeCanceled {
+			t.Fatalf("got = %v, want %v", code, ErrorCodeCanceled)
+		}
+		if got := early.Load(); got != 0 {
+			t.Fatalf("got = %v, want %v", got, 0)
+		}
+	})
+}
+
+func TestServer_ResponseHeaders(t *testing.T) {
+	clientURL := startNatsServer(t)
+
+	srv, err := NewServer(&ServerConfig{
+		NatsURL: clientURL,
+		Name:    "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Handle("cached", func(ctx context.Context, r Request) Response {
+		SetResponseHeader(ctx, "Cache-Control", "max-age=60")
+		resp, _ := NewResponse(r.Reply, map[string]string{"hello": "world"})
+		resp.Header = nats.Header{}
+		resp.Header.Set("Cache-Control", "no-store")
+		resp.Header.Set("X-Handler", "true")
+		return resp
+	}, WithEndpointMiddleware(func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, r Request) Response {
+			resp := next(ctx, r)
+			AddResponseHeader(ctx, "Warning", "299 - \"deprecated\"")
+			return resp
+		}
+	}))
+	srv.Handle("failed", func(ctx context.Context, r Request) Response {
+		SetResponseHeader(ctx, "Retry-After", "1")
+		return NewErrorResponse(r.Reply, Errorf(ErrorCodeUnavailable, "try again"))
+	})
+
+	go func() {
+		_ = srv.Run()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown(context.Background())
+	})
+
+	if !srv.ready(250 * time.Millisecond) {
+		t.Fatal("server not ready after 250 milliseconds")
+	}
+
+	client, err := NewClient(clientURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	tests := []struct {
+		name    string
+		subject string
+		wantErr ErrorCode
+		want    map[string]string
+	}{
+		{
+			name:    "headers set from context",
+			subject: "cached",
+			want: map[string]string{
+				"Cache-Control": "max-age=60",
+				"X-Handler":     "true",
+				"Warning":       "299 - \"deprecated\"",
+			},
+		},
+		{
+			name:    "error response",
+			subject: "failed",
+			wantErr: ErrorCodeUnavailable,
+			want: map[string]string{
+				"Retry-After": "1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := nats.Header{}
+			resp := client.Do(
+				ctxWithTimeout(t, time.Second),
+				mustNewRequest(t, tt.subject, nil),
+				WithResponseHeader(header),
+			)
+			if CodeFromErr(resp.Err) != tt.wantErr {
+				t.Fatalf("got = %v, want %v", CodeFromErr(resp.Err), tt.wantErr)
+			}
+			for k, v := range tt.want {
+				if got := header.Get(k); got != v {
+					t.Fatalf("got = %v, want %v", got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleWithEndpointOptions(t *testing.T) {
+	clientURL := startNatsServer(t)
+
+	srv, err := NewServer(&ServerConfig{
+		NatsURL: clientURL,
+		Name:    "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Handle("timeout", func(ctx context.Context, r Request) Response {
+		dl, ok := ctx.Deadline()
+		if !ok {
+			return NewErrorResponse(r.Reply, fmt.Errorf("context should have deadline"))
+		}
+		if time.Until(dl) > 100*time.Millisecond {
+			return NewErrorResponse(r.Reply, fmt.Errorf("expected endpoint timeout to be used"))
+		}
+		return Response{Msg: &nats.Msg{Subject: r.Reply}}
+	}, WithEndpointTimeout(100*time.Millisecond))
+	srv.Handle("middleware", func(ctx context.Context, r Request) Response {
+		return Response{Msg: &nats.Msg{Subject: r.Reply}}
+	}, WithEndpointMiddleware(func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, r Request) Response {
+			resp := next(ctx, r)
+			resp.Header = nats.Header{}
+			resp.Header.Set("X-Endpoint", "middleware")
+			return resp
+		}
+	}))
+	srv.Handle("metadata", func(ctx context.Context, r Request) Response {
+		return Response{Msg: &nats.Msg{Subject: r.Reply}}
+	}, WithEndpointMetadata(map[string]string{"version": "2"}), WithQueueGroup("workers"))
+	srv.Handle("broadcast", func(ctx context.Context, r Request) Response {
+		return Response{Msg: &nats.Msg{Subject: r.Reply}}
+	}, WithNoQueueGroup())
+
+	go func() {
+		_ = srv.Run()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown(context.Background())
+	})
+
+	if !srv.ready(250 * time.Millisecond) {
+		t.Fatal("server not ready after 250 milliseconds")
+	}
+
+	client, err := NewClient(clientURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	t.Run("timeout", func(t *testing.T) {
+		resp := client.Do(context.Background(), mustNewRequest(t, "timeout", 1))
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+	})
+
+	t.Run("middleware", func(t *testing.T) {
+		resp := client.Do(ctxWithTimeout(t, 1*time.Second), mustNewRequest(t, "middleware", 1))
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+		if got := resp.Header.Get("X-Endpoint"); got != "middleware" {
+			t.Fatalf("got = %v, want %v", got, "middleware")
+		}
+
+		resp = client.Do(ctxWithTimeout(t, 1*time.Second), mustNewRequest(t, "metadata", 1))
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+		if got := resp.Header.Get("X-Endpoint"); got != "" {
+			t.Fatalf("expected endpoint middleware to only apply to its endpoint got = %v", got)
+		}
+	})
+
+	t.Run("info", func(t *testing.T) {
+		endpoints := make(map[string]micro.EndpointInfo)
+		for _, ep := range srv.svc.Info().Endpoints {
+			endpoints[ep.Subject] = ep
+		}
+
+		if got := endpoints["metadata"].Metadata["version"]; got != "2" {
+			t.Errorf("got metadata = %v, want %v", got, "2")
+		}
+		if got := endpoints["metadata"].QueueGroup; got != "workers" {
+			t.Errorf("got queue group = %v, want %v", got, "workers")
+		}
+		if got := endpoints["timeout"].QueueGroup; got != micro.DefaultQueueGroup {
+			t.Errorf("got queue group = %v, want %v", got, micro.DefaultQueueGroup)
+		}
+		if got := endpoints["broadcast"].QueueGroup; got != "" {
+			t.Errorf("got queue group = %v, want no queue group", got)
+		}
+	})
+}
+
 func TestServer_Subjects(t *testing.T) {
 	type endpoint struct {
 		name    string
@@ -544,6 +1383,32 @@ func startNatsServer(tb testing.TB) string {
 	return ns.ClientURL()
 }
 
+func startJetStreamServer(tb testing.TB) string {
+	tb.Helper()
+
+	ns, err := server.NewServer(&server.Options{
+		Port:      40898,
+		JetStream: true,
+		StoreDir:  tb.TempDir(),
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	ns.Start()
+
+	tb.Cleanup(func() {
+		ns.Shutdown()
+		ns.WaitForShutdown()
+	})
+
+	if !ns.ReadyForConnections(1 * time.Second) {
+		tb.Fatal("timeout waiting for nats server")
+	}
+
+	return ns.ClientURL()
+}
+
 func mustNewRequest(tb testing.TB, subject string, body any, opts ...RequestOption) Request {
 	req, err := NewRequest(subject, body, opts...)
 	if err != nil {
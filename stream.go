@@ -0,0 +1,588 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+var errStreamNotWritable = errors.New("stormrpc: stream does not accept messages from the client")
+
+// StreamHandlerFunc is the function signature for handling a streaming request.
+// Messages are sent to the caller via the ServerStream. Returning a nil error ends the stream
+// successfully, returning a non-nil error ends the stream with that error.
+//
+// For streams opened with Client.OpenStream the ServerStream can also be used to receive
+// messages from the caller.
+type StreamHandlerFunc func(ctx context.Context, r Request, s *ServerStream) error
+
+// StreamHandler adapts a StreamHandlerFunc into a HandlerFunc so it can be registered on a Server
+// and wrapped by Middleware. The Response returned by the adapted HandlerFunc is the end of stream message.
+func StreamHandler(fn StreamHandlerFunc) HandlerFunc {
+	return func(ctx context.Context, r Request) Response {
+		srv := serverFromContext(ctx)
+		if srv == nil || r.Reply == "" {
+			return NewErrorResponse(r.Reply, Errorf(ErrorCodeInternal, "streaming is not supported for this request"))
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		stream := &ServerStream{
+			ctx:   ctx,
+			nc:    srv.nc,
+			reply: r.Reply,
+		}
+
+		if r.Header.Get(streamOpenHeader) != "" {
+			stream.recv = newStreamReceiver(cancel)
+			sub, err := srv.nc.Subscribe(srv.nc.NewInbox(), stream.recv.handle)
+			if err != nil {
+				return NewErrorResponse(r.Reply, Errorf(ErrorCodeInternal, "failed to open stream: %v", err))
+			}
+			defer func() {
+				_ = sub.Unsubscribe()
+			}()
+
+			// The handshake tells the client where to send its messages.
+			header := nats.Header{}
+			header.Set(streamInboxHeader, sub.Subject)
+			if err = stream.publish(&nats.Msg{Header: header}); err != nil {
+				return NewErrorResponse(r.Reply, Errorf(ErrorCodeInternal, "failed to open stream: %v", err))
+			}
+		}
+
+		err := fn(ctx, r, stream)
+
+		header := nats.Header{}
+		header.Set(streamEndHeader, "true")
+		stream.mu.Lock()
+		setStreamSeqHeader(header, stream.seq)
+		stream.seq++
+		stream.mu.Unlock()
+
+		return Response{
+			Msg: &nats.Msg{
+				Subject: r.Reply,
+				Header:  header,
+			},
+			Err: err,
+		}
+	}
+}
+
+// HandleStream registers a new StreamHandlerFunc on the server. Each stream is handled in its own
+// goroutine so open streams don't hold up other requests to the subject, and streams have no timeout
+// unless the client sets a deadline or the endpoint is configured with WithEndpointTimeout.
+// The server's WithConcurrency option doesn't apply to streams, use WithEndpointConcurrency to limit them.
+func (s *Server) HandleStream(subject string, fn StreamHandlerFunc, opts ...EndpointOption) {
+	s.Handle(subject, StreamHandler(fn), append([]EndpointOption{streamEndpointOption{}}, opts...)...)
+}
+
+// ServerStream is used by a StreamHandlerFunc to exchange messages with the caller.
+type ServerStream struct {
+	ctx   context.Context
+	nc    *nats.Conn
+	reply string
+
+	mu       sync.Mutex
+	seq      uint64
+	recv     *streamReceiver
+	recvDone bool
+}
+
+// Context returns the context of the request the stream belongs to. The context is cancelled
+// if the caller cancels the stream.
+func (s *ServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// Send sends a single message to the caller. The response body and headers are sent as is,
+// use NewResponse to handle encoding.
+func (s *ServerStream) Send(resp Response) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+
+	if resp.Err != nil {
+		return resp.Err
+	}
+
+	msg := &nats.Msg{}
+	if resp.Msg != nil {
+		msg.Header = resp.Header
+		msg.Data = resp.Data
+	}
+
+	return s.publish(msg)
+}
+
+// Recv blocks until the next message from the caller is received. Once the caller has
+// closed its side of the stream io.EOF is returned. Streams started with Client.Stream
+// never receive messages and always return io.EOF.
+func (s *ServerStream) Recv() (Request, error) {
+	s.mu.Lock()
+	done := s.recv == nil || s.recvDone
+	s.mu.Unlock()
+	if done {
+		return Request{}, io.EOF
+	}
+
+	msg, err := s.recv.next(s.ctx)
+	if err != nil {
+		return Request{}, err
+	}
+
+	if msg.Header.Get(streamEndHeader) != "" {
+		s.mu.Lock()
+		s.recvDone = true
+		s.mu.Unlock()
+		return Request{}, io.EOF
+	}
+
+	return Request{Msg: msg}, nil
+}
+
+func (s *ServerStream) publish(msg *nats.Msg) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	msg.Subject = s.reply
+	setStreamSeqHeader(msg.Header, s.seq)
+	s.seq++
+
+	return s.nc.PublishMsg(msg)
+}
+
+// ClientStream is used to exchange messages with a streaming RPC.
+type ClientStream struct {
+	ctx     context.Context
+	nc      *nats.Conn
+	sub     *nats.Subscription
+	subject string
+	recv    *streamReceiver
+
+	// inbox is the subject the server receives messages on, it is empty
+	// for streams started with Client.Stream.
+	inbox string
+	// cancelID is the id the server cancels a stream started with Client.Stream by.
+	cancelID   string
+	stop       func() bool
+	cancelOnce sync.Once
+
+	mu         sync.Mutex
+	seq        uint64
+	sendClosed bool
+	done       bool
+}
+
+// Stream starts a server-streaming request to a stormRPC Server. The returned ClientStream
+// should be closed once the caller is done receiving messages. Cancelling ctx or calling Close
+// before the server ended the stream cancels the stream on the server as well.
+func (c *Client) Stream(ctx context.Context, r Request, opts ...CallOption) (*ClientStream, error) {
+	options := callOptions{
+		headers: make(map[string]string),
+	}
+	for _, o := range opts {
+		err := o.before(&options)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.applyOptions(ctx, &r, &options)
+
+	dl, ok := ctx.Deadline()
+	if ok {
+		setDeadlineHeader(r.Header, dl)
+	}
+	id := uuid.NewString()
+	r.Header.Set(cancelIDHeader, id)
+
+	s, err := c.startStream(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	s.cancelID = id
+	s.stop = context.AfterFunc(ctx, s.cancel)
+
+	return s, nil
+}
+
+// OpenStream opens a stream to a stormRPC Server on which both the client and the server can
+// send messages, which is used for client-streaming and bidirectional-streaming RPCs.
+// The client signals it's done sending with CloseSend. Cancelling ctx or calling Close
+// before the server ended the stream cancels the stream on the server as well.
+func (c *Client) OpenStream(ctx context.Context, subject string, opts ...CallOption) (*ClientStream, error) {
+	options := callOptions{
+		headers: make(map[string]string),
+	}
+	for _, o := range opts {
+		err := o.before(&options)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r := Request{
+		Msg: &nats.Msg{
+			Subject: subject,
+			Header:  nats.Header{},
+		},
+	}
+	c.applyOptions(ctx, &r, &options)
+
+	dl, ok := ctx.Deadline()
+	if ok {
+		setDeadlineHeader(r.Header, dl)
+	}
+	r.Header.Set(streamOpenHeader, "true")
+
+	s, err := c.startStream(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := s.receive()
+	if resp.Err != nil {
+		_ = s.Close()
+		return nil, resp.Err
+	}
+
+	s.inbox = resp.Header.Get(streamInboxHeader)
+	if s.inbox == "" {
+		_ = s.Close()
+		return nil, Errorf(ErrorCodeInternal, "failed to open stream for subject: %s", subject)
+	}
+	s.stop = context.AfterFunc(ctx, s.cancel)
+
+	return s, nil
+}
+
+func (c *Client) startStream(ctx context.Context, r Request) (*ClientStream, error) {
+	s := &ClientStream{
+		ctx:     ctx,
+		nc:      c.nc,
+		subject: r.Subject(),
+		recv:    newStreamReceiver(nil),
+	}
+
+	sub, err := c.nc.Subscribe(c.nc.NewInbox(), s.recv.handle)
+	if err != nil {
+		return nil, err
+	}
+	s.sub = sub
+
+	r.Reply = sub.Subject
+	if err = c.nc.PublishMsg(r.Msg); err != nil {
+		_ = sub.Unsubscribe()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Recv blocks until the next message of the stream is received. Once the stream has ended
+// the returned Response has an Err of io.EOF. If the stream ended because of an error,
+// that error is returned instead.
+func (s *ClientStream) Recv() Response {
+	s.mu.Lock()
+	done := s.done
+	s.mu.Unlock()
+	if done {
+		return NewErrorResponse("", io.EOF)
+	}
+
+	resp := s.receive()
+	if resp.Err != nil {
+		_ = s.Close()
+	}
+
+	return resp
+}
+
+func (s *ClientStream) receive() Response {
+	msg, err := s.recv.next(s.ctx)
+	if err != nil {
+		return NewErrorResponse("", err)
+	}
+
+	if isNoResponders(msg) {
+		return Response{
+			Msg: msg,
+			Err: requestError(nats.ErrNoResponders, s.subject),
+		}
+	}
+
+	rpcErr := parseErrorHeader(msg.Header)
+	if rpcErr != nil {
+		return Response{
+			Msg: msg,
+			Err: rpcErr,
+		}
+	}
+
+	if msg.Header.Get(streamEndHeader) != "" {
+		return Response{
+			Msg: msg,
+			Err: io.EOF,
+		}
+	}
+
+	return Response{
+		Msg: msg,
+		Err: nil,
+	}
+}
+
+// Send sends a single message to the server. The request body and headers are sent as is,
+// use NewRequest to handle encoding. Once the server has ended the stream io.EOF is returned.
+func (s *ClientStream) Send(r Request) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.inbox == "" {
+		return errStreamNotWritable
+	}
+	if s.sendClosed {
+		return Errorf(ErrorCodeInternal, "send on closed stream")
+	}
+	if s.done || s.recv.isEnded() {
+		return io.EOF
+	}
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+
+	msg := &nats.Msg{
+		Subject: s.inbox,
+		Header:  nats.Header{},
+	}
+	if r.Msg != nil {
+		for k, v := range r.Header {
+			msg.Header[k] = v
+		}
+		msg.Data = r.Data
+	}
+
+	return s.publish(msg)
+}
+
+// CloseSend closes the sending side of the stream, signalling to the server that
+// no more messages will be sent. Messages can still be received until the server ends the stream.
+func (s *ClientStream) CloseSend() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.inbox == "" {
+		return errStreamNotWritable
+	}
+	if s.sendClosed || s.done || s.recv.isEnded() {
+		return nil
+	}
+	s.sendClosed = true
+
+	header := nats.Header{}
+	header.Set(streamEndHeader, "true")
+
+	return s.publish(&nats.Msg{
+		Subject: s.inbox,
+		Header:  header,
+	})
+}
+
+// CloseAndRecv closes the sending side of the stream and waits for the single response
+// of a client-streaming RPC.
+func (s *ClientStream) CloseAndRecv() Response {
+	if err := s.CloseSend(); err != nil {
+		return NewErrorResponse("", err)
+	}
+
+	resp := s.Recv()
+	if resp.Err != nil {
+		return resp
+	}
+
+	end := s.Recv()
+	if end.Err == nil {
+		_ = s.Close()
+		return NewErrorResponse("", Errorf(ErrorCodeInternal, "received more than one response for subject: %s", s.subject))
+	}
+	if !errors.Is(end.Err, io.EOF) {
+		return end
+	}
+
+	return resp
+}
+
+// Close stops receiving messages from the stream. If the server hasn't ended the stream yet
+// it is notified that the stream was cancelled.
+func (s *ClientStream) Close() error {
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return nil
+	}
+	s.done = true
+	s.mu.Unlock()
+
+	if s.stop != nil {
+		s.stop()
+	}
+	if !s.recv.isEnded() {
+		s.cancel()
+	}
+	s.recv.close()
+
+	return s.sub.Unsubscribe()
+}
+
+// cancel notifies the server that the stream was cancelled.
+func (s *ClientStream) cancel() {
+	if s.inbox == "" && s.cancelID == "" {
+		return
+	}
+
+	s.cancelOnce.Do(func() {
+		// Server streams are cancelled the same way as requests made with Client.Do.
+		if s.inbox == "" {
+			_ = s.nc.Publish(cancelSubjectPrefix+s.cancelID, nil)
+			return
+		}
+
+		header := nats.Header{}
+		header.Set(streamCancelHeader, "true")
+		_ = s.nc.PublishMsg(&nats.Msg{
+			Subject: s.inbox,
+			Header:  header,
+		})
+	})
+}
+
+// publish must be called with s.mu held.
+func (s *ClientStream) publish(msg *nats.Msg) error {
+	setStreamSeqHeader(msg.Header, s.seq)
+	s.seq++
+
+	return s.nc.PublishMsg(msg)
+}
+
+// streamReceiver orders incoming stream messages by their sequence number and
+// reacts to cancellation messages as soon as they arrive.
+type streamReceiver struct {
+	mu       sync.Mutex
+	expected uint64
+	pending  map[uint64]*nats.Msg
+	ready    []*nats.Msg
+	ended    bool
+	notify   chan struct{}
+	closed   chan struct{}
+	onCancel func()
+}
+
+func newStreamReceiver(onCancel func()) *streamReceiver {
+	return &streamReceiver{
+		pending:  make(map[uint64]*nats.Msg),
+		notify:   make(chan struct{}, 1),
+		closed:   make(chan struct{}),
+		onCancel: onCancel,
+	}
+}
+
+// handle is used as the nats subscription handler for the stream's inbox.
+func (r *streamReceiver) handle(msg *nats.Msg) {
+	if msg.Header.Get(streamCancelHeader) != "" {
+		if r.onCancel != nil {
+			r.onCancel()
+		}
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seq, ok := parseStreamSeqHeader(msg.Header)
+	switch {
+	case !ok:
+		// Messages not belonging to the stream protocol (e.g. no responders) are delivered as is.
+		r.push(msg)
+	case seq < r.expected:
+		// Duplicate message.
+	case seq > r.expected:
+		r.pending[seq] = msg
+	default:
+		r.push(msg)
+		r.expected++
+		for {
+			next, ok := r.pending[r.expected]
+			if !ok {
+				break
+			}
+			delete(r.pending, r.expected)
+			r.push(next)
+			r.expected++
+		}
+	}
+}
+
+// push must be called with r.mu held.
+func (r *streamReceiver) push(msg *nats.Msg) {
+	if msg.Header.Get(streamEndHeader) != "" {
+		r.ended = true
+	}
+	r.ready = append(r.ready, msg)
+
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// next blocks until the next in order message is available.
+func (r *streamReceiver) next(ctx context.Context) (*nats.Msg, error) {
+	for {
+		r.mu.Lock()
+		if len(r.ready) > 0 {
+			msg := r.ready[0]
+			r.ready = r.ready[1:]
+			r.mu.Unlock()
+			return msg, nil
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-r.notify:
+		case <-r.closed:
+			return nil, io.EOF
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// close unblocks any pending calls to next.
+func (r *streamReceiver) close() {
+	close(r.closed)
+}
+
+// isEnded reports whether the end of stream message has been received.
+func (r *streamReceiver) isEnded() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.ended
+}
+
+// isNoResponders reports whether the message is the status message sent by the nats server
+// when there are no subscribers for a subject.
+func isNoResponders(msg *nats.Msg) bool {
+	return len(msg.Data) == 0 && msg.Header.Get("Status") == "503"
+}
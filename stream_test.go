@@ -0,0 +1,555 @@
+// Package stormrpc provides the functionality for creating RPC servers/clients that communicate via NATS.
+package stormrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestClient_Stream(t *testing.T) {
+	clientURL := startNatsServer(t)
+
+	srv, err := NewServer(&ServerConfig{
+		NatsURL: clientURL,
+		Name:    "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.HandleStream("count", func(ctx context.Context, r Request, s *ServerStream) error {
+		var n int
+		if err := r.Decode(&n); err != nil {
+			return err
+		}
+
+		for i := 0; i < n; i++ {
+			resp, err := NewResponse(r.Reply, i)
+			if err != nil {
+				return err
+			}
+			if err = s.Send(resp); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	srv.HandleStream("fail", func(ctx context.Context, r Request, s *ServerStream) error {
+		resp, err := NewResponse(r.Reply, 1)
+		if err != nil {
+			return err
+		}
+		if err = s.Send(resp); err != nil {
+			return err
+		}
+
+		return Errorf(ErrorCodeNotFound, "thingy not found")
+	})
+	stopped := make(chan error, 1)
+	srv.HandleStream("forever", func(ctx context.Context, r Request, s *ServerStream) error {
+		for {
+			resp, err := NewResponse(r.Reply, "tick")
+			if err != nil {
+				return err
+			}
+			if err = s.Send(resp); err != nil {
+				stopped <- err
+				return err
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+	// Registered the way generated code registers streaming methods.
+	srv.Handle("deadline", StreamHandler(func(ctx context.Context, r Request, s *ServerStream) error {
+		_, ok := ctx.Deadline()
+		resp, err := NewResponse(r.Reply, ok)
+		if err != nil {
+			return err
+		}
+		return s.Send(resp)
+	}), WithStreamEndpoint())
+
+	go func() {
+		_ = srv.Run()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown(context.Background())
+	})
+
+	if !srv.ready(250 * time.Millisecond) {
+		t.Fatal("server not ready after 250 milliseconds")
+	}
+
+	client, err := NewClient(clientURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	t.Run("stream endpoint option", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		stream, err := client.Stream(ctx, mustNewRequest(t, "deadline", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.Close()
+
+		resp := stream.Recv()
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+
+		var hasDeadline bool
+		if err = resp.Decode(&hasDeadline); err != nil {
+			t.Fatal(err)
+		}
+		if hasDeadline {
+			t.Fatalf("got = %v, want %v", hasDeadline, false)
+		}
+	})
+
+	t.Run("receives all messages", func(t *testing.T) {
+		stream, err := client.Stream(ctxWithTimeout(t, 1*time.Second), mustNewRequest(t, "count", 3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.Close()
+
+		for want := 0; want < 3; want++ {
+			resp := stream.Recv()
+			if resp.Err != nil {
+				t.Fatal(resp.Err)
+			}
+
+			var got int
+			if err = resp.Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Fatalf("got = %v, want %v", got, want)
+			}
+		}
+
+		resp := stream.Recv()
+		if !errors.Is(resp.Err, io.EOF) {
+			t.Fatalf("got = %v, want %v", resp.Err, io.EOF)
+		}
+
+		resp = stream.Recv()
+		if !errors.Is(resp.Err, io.EOF) {
+			t.Fatalf("got = %v, want %v after end of stream", resp.Err, io.EOF)
+		}
+	})
+
+	t.Run("error ends stream", func(t *testing.T) {
+		stream, err := client.Stream(ctxWithTimeout(t, 1*time.Second), mustNewRequest(t, "fail", 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.Close()
+
+		resp := stream.Recv()
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+
+		resp = stream.Recv()
+		if code := CodeFromErr(resp.Err); code != ErrorCodeNotFound {
+			t.Fatalf("got = %v, want %v", code, ErrorCodeNotFound)
+		}
+		if msg := MessageFromErr(resp.Err); msg != "thingy not found" {
+			t.Fatalf("got = %v, want %v", msg, "thingy not found")
+		}
+	})
+
+	t.Run("error is sent once", func(t *testing.T) {
+		inbox := nats.NewInbox()
+		sub, err := client.nc.SubscribeSync(inbox)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = sub.Unsubscribe()
+		}()
+
+		r := mustNewRequest(t, "fail", 0)
+		r.Reply = inbox
+		if err = client.nc.PublishMsg(r.Msg); err != nil {
+			t.Fatal(err)
+		}
+
+		var ends int
+		for {
+			msg, err := sub.NextMsg(100 * time.Millisecond)
+			if errors.Is(err, nats.ErrTimeout) {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Header.Get(streamEndHeader) != "" {
+				ends++
+			}
+		}
+
+		if ends != 1 {
+			t.Fatalf("got = %v end of stream messages, want %v", ends, 1)
+		}
+	})
+
+	t.Run("close cancels the stream on the server", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		stream, err := client.Stream(ctx, mustNewRequest(t, "forever", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp := stream.Recv(); resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+		if err = stream.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case err := <-stopped:
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("got = %v, want %v", err, context.Canceled)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("server stream wasn't cancelled")
+		}
+	})
+
+	t.Run("no servers", func(t *testing.T) {
+		subject := strconv.Itoa(rand.Int())
+		stream, err := client.Stream(ctxWithTimeout(t, 1*time.Second), mustNewRequest(t, subject, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.Close()
+
+		resp := stream.Recv()
+		if code := CodeFromErr(resp.Err); code != ErrorCodeUnavailable {
+			t.Fatalf("got = %v, want %v", code, ErrorCodeUnavailable)
+		}
+	})
+}
+
+func TestClient_OpenStream(t *testing.T) {
+	clientURL := startNatsServer(t)
+
+	// The server's pools don't apply to streams, so concurrent streams aren't rejected.
+	srv, err := NewServer(&ServerConfig{
+		NatsURL: clientURL,
+		Name:    "test",
+	}, WithConcurrency(Concurrency{MaxInFlight: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Streams aren't subject to the server's default timeout.
+	srv.timeout = 50 * time.Millisecond
+
+	cancelled := make(chan struct{})
+	srv.HandleStream("sum", func(ctx context.Context, r Request, s *ServerStream) error {
+		var sum int
+		for {
+			req, err := s.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			var n int
+			if err = req.Decode(&n); err != nil {
+				return err
+			}
+			sum += n
+		}
+
+		resp, err := NewResponse(r.Reply, sum)
+		if err != nil {
+			return err
+		}
+
+		return s.Send(resp)
+	})
+	srv.HandleStream("echo", func(ctx context.Context, r Request, s *ServerStream) error {
+		for {
+			req, err := s.Recv()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			if err = s.Send(Response{Msg: req.Msg}); err != nil {
+				return err
+			}
+		}
+	})
+	srv.HandleStream("block", func(ctx context.Context, r Request, s *ServerStream) error {
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	})
+
+	go func() {
+		_ = srv.Run()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown(context.Background())
+	})
+
+	if !srv.ready(250 * time.Millisecond) {
+		t.Fatal("server not ready after 250 milliseconds")
+	}
+
+	client, err := NewClient(clientURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	t.Run("client streaming", func(t *testing.T) {
+		stream, err := client.OpenStream(ctxWithTimeout(t, 1*time.Second), "sum")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.Close()
+
+		for i := 1; i <= 4; i++ {
+			if err = stream.Send(mustNewRequest(t, "sum", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		resp := stream.CloseAndRecv()
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+
+		var got int
+		if err = resp.Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != 10 {
+			t.Fatalf("got = %v, want %v", got, 10)
+		}
+
+		if err = stream.Send(mustNewRequest(t, "sum", 1)); err == nil {
+			t.Fatal("expected error sending on closed stream got nil")
+		}
+	})
+
+	t.Run("bidirectional streaming", func(t *testing.T) {
+		stream, err := client.OpenStream(ctxWithTimeout(t, 1*time.Second), "echo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.Close()
+
+		for i := 0; i < 3; i++ {
+			if err = stream.Send(mustNewRequest(t, "echo", i)); err != nil {
+				t.Fatal(err)
+			}
+
+			resp := stream.Recv()
+			if resp.Err != nil {
+				t.Fatal(resp.Err)
+			}
+
+			var got int
+			if err = resp.Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != i {
+				t.Fatalf("got = %v, want %v", got, i)
+			}
+		}
+
+		if err = stream.CloseSend(); err != nil {
+			t.Fatal(err)
+		}
+
+		resp := stream.Recv()
+		if !errors.Is(resp.Err, io.EOF) {
+			t.Fatalf("got = %v, want %v", resp.Err, io.EOF)
+		}
+	})
+
+	t.Run("concurrent streams", func(t *testing.T) {
+		ctx := ctxWithTimeout(t, 1*time.Second)
+		first, err := client.OpenStream(ctx, "echo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer first.Close()
+
+		second, err := client.OpenStream(ctx, "echo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer second.Close()
+
+		for i, stream := range []*ClientStream{second, first} {
+			if err = stream.Send(mustNewRequest(t, "echo", i)); err != nil {
+				t.Fatal(err)
+			}
+
+			resp := stream.Recv()
+			if resp.Err != nil {
+				t.Fatal(resp.Err)
+			}
+
+			var got int
+			if err = resp.Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != i {
+				t.Fatalf("got = %v, want %v", got, i)
+			}
+		}
+	})
+
+	t.Run("no timeout without deadline", func(t *testing.T) {
+		stream, err := client.OpenStream(context.Background(), "echo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.Close()
+
+		time.Sleep(100 * time.Millisecond)
+
+		if err = stream.Send(mustNewRequest(t, "echo", 1)); err != nil {
+			t.Fatal(err)
+		}
+		if err = stream.CloseSend(); err != nil {
+			t.Fatal(err)
+		}
+
+		resp := stream.Recv()
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+
+		resp = stream.Recv()
+		if !errors.Is(resp.Err, io.EOF) {
+			t.Fatalf("got = %v, want %v", resp.Err, io.EOF)
+		}
+	})
+
+	t.Run("cancellation is propagated to the server", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		stream, err := client.OpenStream(ctx, "block")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.Close()
+
+		cancel()
+
+		select {
+		case <-cancelled:
+		case <-time.After(1 * time.Second):
+			t.Fatal("expected server stream to be cancelled")
+		}
+
+		resp := stream.Recv()
+		if !errors.Is(resp.Err, context.Canceled) {
+			t.Fatalf("got = %v, want %v", resp.Err, context.Canceled)
+		}
+	})
+
+	t.Run("no servers", func(t *testing.T) {
+		subject := strconv.Itoa(rand.Int())
+		_, err := client.OpenStream(ctxWithTimeout(t, 1*time.Second), subject)
+		if code := CodeFromErr(err); code != ErrorCodeUnavailable {
+			t.Fatalf("got = %v, want %v", code, ErrorCodeUnavailable)
+		}
+	})
+
+	t.Run("server streams don't accept messages", func(t *testing.T) {
+		stream, err := client.Stream(ctxWithTimeout(t, 1*time.Second), mustNewRequest(t, "sum", 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.Close()
+
+		if err = stream.Send(mustNewRequest(t, "sum", 1)); !errors.Is(err, errStreamNotWritable) {
+			t.Fatalf("got = %v, want %v", err, errStreamNotWritable)
+		}
+	})
+}
+
+func Test_streamReceiver(t *testing.T) {
+	t.Run("orders messages by sequence", func(t *testing.T) {
+		r := newStreamReceiver(nil)
+		for _, seq := range []uint64{2, 0, 0, 3, 1} {
+			header := nats.Header{}
+			setStreamSeqHeader(header, seq)
+			r.handle(&nats.Msg{Header: header})
+		}
+
+		for want := uint64(0); want < 4; want++ {
+			msg, err := r.next(ctxWithTimeout(t, 100*time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, _ := parseStreamSeqHeader(msg.Header)
+			if got != want {
+				t.Fatalf("got = %v, want %v", got, want)
+			}
+		}
+
+		if _, err := r.next(ctxWithTimeout(t, 10*time.Millisecond)); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("cancel message", func(t *testing.T) {
+		var called bool
+		r := newStreamReceiver(func() { called = true })
+
+		header := nats.Header{}
+		header.Set(streamCancelHeader, "true")
+		r.handle(&nats.Msg{Header: header})
+
+		if !called {
+			t.Fatal("expected cancel func to be called")
+		}
+	})
+}
+
+func TestStreamHandler(t *testing.T) {
+	t.Run("no connection in context", func(t *testing.T) {
+		h := StreamHandler(func(ctx context.Context, r Request, s *ServerStream) error {
+			t.Fatal("handler should not be called")
+			return nil
+		})
+
+		resp := h(context.Background(), mustNewRequest(t, "test", 0))
+		if code := CodeFromErr(resp.Err); code != ErrorCodeInternal {
+			t.Fatalf("got = %v, want %v", code, ErrorCodeInternal)
+		}
+	})
+}